@@ -0,0 +1,327 @@
+// authors.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	ErrAuthorNotFound = errors.New("author not found")
+	ErrAuthorExists   = errors.New("author already exists")
+	ErrAuthorInvalid  = errors.New("author needs an Email")
+	ErrAuthorHasPosts = errors.New("author still has articles, delete with ?cascade=true to remove them too")
+	ErrUnknownAuthor  = errors.New("unknown authorId")
+)
+
+// authorKey - authors are keyed by their Email, compared case-insensitively
+func authorKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// authorStore keeps the authors in memory, in persistent mode it also
+// rewrites path on every change
+type authorStore struct {
+	mu      sync.RWMutex
+	authors []Author
+	path    string
+	changed func() // called after an author is updated, the feeds show the names, see newServer
+}
+
+// newAuthorStore loads the authors saved at path, an empty path keeps them in memory only
+func newAuthorStore(path string) (*authorStore, error) {
+	s := &authorStore{path: path}
+	if path != "" {
+		if err := loadJSONFile(path, &s.authors); err != nil {
+			return nil, fmt.Errorf("authors %s: %v", path, err)
+		}
+	}
+	return s, nil
+}
+
+// indexOf must be called with s.mu held
+func (s *authorStore) indexOf(email string) int {
+	for index, author := range s.authors {
+		if authorKey(author.Email) == authorKey(email) {
+			return index
+		}
+	}
+	return -1
+}
+
+// save must be called with s.mu held
+func (s *authorStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	return saveJSONFile(s.path, s.authors)
+}
+
+func (s *authorStore) Get(email string) (Author, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	index := s.indexOf(email)
+	if index < 0 {
+		return Author{}, ErrAuthorNotFound
+	}
+	return s.authors[index], nil
+}
+
+// check refuses an AuthorId without an author
+func (s *authorStore) check(email string) error {
+	if _, err := s.Get(email); err != nil {
+		return fmt.Errorf("%w %q", ErrUnknownAuthor, email)
+	}
+	return nil
+}
+
+func (s *authorStore) List() []Author {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Author{}, s.authors...)
+}
+
+func (s *authorStore) Create(author Author) (Author, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	author.Email = strings.TrimSpace(author.Email)
+	if author.Email == "" {
+		return Author{}, ErrAuthorInvalid
+	}
+	if s.indexOf(author.Email) >= 0 {
+		return Author{}, ErrAuthorExists
+	}
+	s.authors = append(s.authors, author)
+	if err := s.save(); err != nil {
+		s.authors = s.authors[:len(s.authors)-1]
+		return Author{}, err
+	}
+	return author, nil
+}
+
+func (s *authorStore) Update(email string, author Author) (Author, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.indexOf(email)
+	if index < 0 {
+		return Author{}, ErrAuthorNotFound
+	}
+	old := s.authors[index]
+	author.Email = old.Email // the email is the key, it cannot change
+	s.authors[index] = author
+	if err := s.save(); err != nil {
+		s.authors[index] = old
+		return Author{}, err
+	}
+	if s.changed != nil {
+		s.changed()
+	}
+	return author, nil
+}
+
+func (s *authorStore) Delete(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.indexOf(email)
+	if index < 0 {
+		return ErrAuthorNotFound
+	}
+	old := s.authors
+	s.authors = append(append([]Author{}, s.authors[:index]...), s.authors[index+1:]...)
+	if err := s.save(); err != nil {
+		s.authors = old
+		return err
+	}
+	return nil
+}
+
+// migrateAuthors turns the Author copies embedded in old articles into
+// author records and makes the articles reference them by AuthorId instead
+func migrateAuthors(store ArticleStore, authors *authorStore) error {
+	articles, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, article := range articles {
+		if article.Author == nil {
+			continue
+		}
+		if article.AuthorId == "" {
+			if _, err := authors.Create(*article.Author); err != nil && err != ErrAuthorExists && err != ErrAuthorInvalid {
+				return err
+			}
+			if article.Author.Email != "" {
+				article.AuthorId = authorKey(article.Author.Email)
+			}
+		}
+		article.Author = nil
+		if _, err := store.Update(article.Id, article); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveAuthor checks the author reference of an article that is about to be written.
+// An embedded author object (the old format) is created on the fly when it does not exist yet.
+func (s *server) resolveAuthor(article *Article) error {
+	if article.Author != nil {
+		if _, err := s.authors.Create(*article.Author); err != nil && err != ErrAuthorExists {
+			return err
+		}
+		article.AuthorId = article.Author.Email
+		article.Author = nil
+	}
+	if article.AuthorId == "" {
+		return nil
+	}
+	if err := s.authors.check(article.AuthorId); err != nil {
+		return err
+	}
+	article.AuthorId = authorKey(article.AuthorId)
+	return nil
+}
+
+// withAuthor fills in the Author of an article from the author store for the response
+func (s *server) withAuthor(article Article) Article {
+	article.Author = nil
+	if article.AuthorId != "" {
+		if author, err := s.authors.Get(article.AuthorId); err == nil {
+			article.Author = &author
+		}
+	}
+	return article
+}
+
+// articlesBy returns the articles that reference the author
+func (s *server) articlesBy(email string) ([]Article, error) {
+	articles, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+	var owned []Article
+	for _, article := range articles {
+		if article.AuthorId == authorKey(email) {
+			owned = append(owned, article)
+		}
+	}
+	return owned, nil
+}
+
+// writeAuthorError maps the author store errors onto HTTP status codes
+func writeAuthorError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrAuthorNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case ErrAuthorExists, ErrAuthorHasPosts:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case ErrAuthorInvalid:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *server) returnAllAuthors(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: returnAllAuthors")
+	render(w, r, s.authors.List())
+}
+
+func (s *server) returnSingleAuthor(w http.ResponseWriter, r *http.Request) {
+	author, err := s.authors.Get(mux.Vars(r)["email"])
+	if err != nil {
+		writeAuthorError(w, err)
+		return
+	}
+	render(w, r, author)
+}
+
+func (s *server) createNewAuthor(w http.ResponseWriter, r *http.Request) {
+	var author Author
+	if err := decodeBody(r, &author); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	author, err := s.authors.Create(author)
+	if err != nil {
+		writeAuthorError(w, err)
+		return
+	}
+	renderStatus(w, r, http.StatusCreated, author)
+}
+
+func (s *server) updateAuthor(w http.ResponseWriter, r *http.Request) {
+	var author Author
+	if err := decodeBody(r, &author); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	author, err := s.authors.Update(mux.Vars(r)["email"], author)
+	if err != nil {
+		writeAuthorError(w, err)
+		return
+	}
+	render(w, r, author)
+}
+
+// deleteAuthor refuses to delete an author who still has articles,
+// unless ?cascade=true asks to move those articles to the trash as well.
+// It all happens under the article write lock, so no article can take the author in the meantime.
+func (s *server) deleteAuthor(w http.ResponseWriter, r *http.Request) {
+	email := mux.Vars(r)["email"]
+	err := s.store.locked(func() error {
+		if _, err := s.authors.Get(email); err != nil {
+			return err
+		}
+		owned, err := s.articlesBy(email)
+		if err != nil {
+			return err
+		}
+		if len(owned) > 0 && r.URL.Query().Get("cascade") != "true" {
+			return ErrAuthorHasPosts
+		}
+		for _, article := range owned {
+			if _, err := s.trashLocked(r, article.Id, nil); err != nil && err != ErrArticleNotFound {
+				return err
+			}
+		}
+		return s.authors.Delete(email)
+	})
+	if err != nil {
+		writeAuthorError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) returnAuthorArticles(w http.ResponseWriter, r *http.Request) {
+	email := mux.Vars(r)["email"]
+	if _, err := s.authors.Get(email); err != nil {
+		writeAuthorError(w, err)
+		return
+	}
+
+	owned, err := s.articlesBy(email)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	visible := []Article{}
+	for _, article := range owned {
+		if isPublished(article) || wantsUnpublished(r) {
+			visible = append(visible, s.present(article))
+		}
+	}
+	render(w, r, visible)
+}
@@ -0,0 +1,78 @@
+// authors_test.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// TestDeleteAuthorRacesCreate - an article created while its author is deleted either
+// goes to the trash with the author or is refused, it never points at a missing author
+func TestDeleteAuthorRacesCreate(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		s := newTestServer(t, newMemoryStore())
+		h := newRouter(s)
+		if w := do(h, "POST", "/authors", `{"Name": "Ann", "Email": "ann@example.com"}`); w.Code != http.StatusCreated {
+			t.Fatalf("create author: %d %s", w.Code, w.Body)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				do(h, "POST", "/article", fmt.Sprintf(`{"title": "post %d", "authorId": "ann@example.com"}`, i))
+			}(i)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do(h, "DELETE", "/authors/ann@example.com?cascade=true", "")
+		}()
+		wg.Wait()
+
+		if _, err := s.authors.Get("ann@example.com"); err == nil {
+			t.Fatalf("round %d: the cascading delete left the author in place", round)
+		}
+		articles, _ := s.store.List()
+		for _, a := range articles {
+			if a.AuthorId == "ann@example.com" {
+				t.Fatalf("round %d: article %s references the deleted author", round, a.Id)
+			}
+		}
+	}
+}
+
+func TestRestoreBringsBackCascadedAuthor(t *testing.T) {
+	s := newTestServer(t, newMemoryStore())
+	h := newRouter(s)
+	var created Article
+	decode(t, do(h, "POST", "/article", `{"title": "Post", "author": {"Name": "Ann", "Email": "ann@example.com"}}`), &created)
+
+	if w := do(h, "DELETE", "/authors/ann@example.com", ""); w.Code != http.StatusConflict {
+		t.Fatalf("deleting an author with articles: got %d, want 409", w.Code)
+	}
+	if w := do(h, "DELETE", "/authors/ann@example.com?cascade=true", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("cascading delete: %d %s", w.Code, w.Body)
+	}
+	if w := do(h, "POST", "/trash/"+created.Id+"/restore", ""); w.Code != http.StatusOK {
+		t.Fatalf("restore: %d %s", w.Code, w.Body)
+	}
+
+	author, err := s.authors.Get("ann@example.com")
+	if err != nil {
+		t.Fatalf("the restored article's author was not brought back: %v", err)
+	}
+	if author.Name != "Ann" {
+		t.Errorf("restored author is %+v, want the deleted record", author)
+	}
+}
+
+func TestCreateWithUnknownAuthor(t *testing.T) {
+	h := newRouter(newTestServer(t, newMemoryStore()))
+	if w := do(h, "POST", "/article", `{"title": "Post", "authorId": "nobody@example.com"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("create with an unknown authorId: got %d, want 400", w.Code)
+	}
+}
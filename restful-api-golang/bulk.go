@@ -0,0 +1,427 @@
+// bulk.go
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// columns of the CSV export, the import accepts them in any order (matched case-insensitively)
+var csvColumns = []string{"Id", "title", "desc", "content", "authorId", "category", "tags", "status", "publishAt", "createdAt", "updatedAt"}
+
+// the tags of an article share one CSV field, separated by csvTagSeparator
+const csvTagSeparator = ";"
+
+// longest NDJSON line the import accepts
+const maxImportLine = 4 << 20
+
+// GET /articles/export?format=ndjson|csv - writes the articles one by one instead of building the whole body first
+func (s *server) exportArticles(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: exportArticles")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		http.Error(w, "format must be ndjson or csv", http.StatusBadRequest)
+		return
+	}
+
+	articles, err := s.store.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+
+	if format == "ndjson" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="articles.ndjson"`)
+		enc := json.NewEncoder(w)
+		for i, article := range articles {
+			article.Author = nil
+			if err := enc.Encode(article); err != nil {
+				return // the client went away
+			}
+			if flusher != nil && i%100 == 99 {
+				flusher.Flush()
+			}
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="articles.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write(csvColumns)
+	for i, a := range articles {
+		publishAt := ""
+		if a.PublishAt != nil {
+			publishAt = a.PublishAt.UTC().Format(time.RFC3339Nano)
+		}
+		cw.Write([]string{
+			a.Id, a.Title, a.Desc, a.Content, a.AuthorId, a.Category, strings.Join(a.Tags, csvTagSeparator), a.Status, publishAt,
+			a.CreatedAt.UTC().Format(time.RFC3339Nano), a.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		})
+		if i%100 == 99 {
+			cw.Flush()
+			if cw.Error() != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	cw.Flush()
+}
+
+// importRow - one parsed line of the import, err is set when it could not be parsed
+type importRow struct {
+	row     int
+	article Article
+	err     error
+}
+
+type importError struct {
+	Row   int    `json:"row"`
+	Id    string `json:"Id,omitempty"`
+	Error string `json:"error"`
+}
+
+// importReport - the answer of POST /articles/import
+type importReport struct {
+	Imported int           `json:"imported"`
+	Failed   int           `json:"failed"`
+	Ids      []string      `json:"ids"`
+	Errors   []importError `json:"errors"`
+	Aborted  bool          `json:"aborted,omitempty"` // all-or-nothing import that was rolled back
+}
+
+// POST /articles/import - NDJSON (application/x-ndjson) or CSV (text/csv) body, read as a stream.
+// Rows with an Id update that article or create it under that id, rows without one get a new id.
+// Updates are checked against If-Match like a PUT, with -require-if-match an import without one can only create.
+// ?atomic=true imports all rows or none: every row is checked first and a failed write rolls back the others.
+func (s *server) importArticles(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: importArticles")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/csv":
+			format = "csv"
+		case "application/x-ndjson", "application/jsonl", "application/json":
+			format = "ndjson"
+		}
+	}
+
+	rows := make(chan importRow)
+	readDone := make(chan error, 1)
+	switch format {
+	case "ndjson":
+		go func() { readDone <- readNDJSON(r.Body, rows) }()
+	case "csv":
+		go func() { readDone <- readCSV(r.Body, rows) }()
+	default:
+		http.Error(w, "send application/x-ndjson or text/csv, or pass ?format=ndjson|csv", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	atomic := r.URL.Query().Get("atomic") == "true"
+	report := importReport{Ids: []string{}, Errors: []importError{}}
+	var valid []importRow
+	for row := range rows {
+		if row.err == nil {
+			row.err = s.checkImport(row.article)
+		}
+		if row.err != nil {
+			report.Failed++
+			report.Errors = append(report.Errors, importError{Row: row.row, Id: row.article.Id, Error: row.err.Error()})
+			continue
+		}
+		if atomic {
+			valid = append(valid, row) // nothing is written before every row has been checked
+			continue
+		}
+		id, _, err := s.importArticle(r, row.article)
+		if err != nil {
+			report.Failed++
+			report.Errors = append(report.Errors, importError{Row: row.row, Id: row.article.Id, Error: err.Error()})
+			continue
+		}
+		report.Imported++
+		report.Ids = append(report.Ids, id)
+	}
+	readErr := <-readDone
+	if readErr != nil {
+		report.Errors = append(report.Errors, importError{Error: readErr.Error()})
+		if atomic {
+			report.Failed += len(valid)
+			valid = nil
+		}
+	}
+
+	if atomic {
+		if report.Failed == 0 && readErr == nil {
+			s.importAll(r, valid, &report)
+		} else {
+			report.Aborted = true
+		}
+	}
+
+	status := http.StatusOK
+	if report.Aborted || (readErr != nil && report.Imported == 0) {
+		status = http.StatusUnprocessableEntity
+	}
+	renderStatus(w, r, status, report)
+}
+
+// importAll writes the checked rows of an atomic import, undoing the earlier ones if a write fails
+func (s *server) importAll(r *http.Request, rows []importRow, report *importReport) {
+	type undo struct {
+		id       string
+		previous *Article // nil when the row created the article
+	}
+	var done []undo
+	for _, row := range rows {
+		id, previous, err := s.importArticle(r, row.article)
+		if err == nil {
+			done = append(done, undo{id, previous})
+			continue
+		}
+
+		for i := len(done) - 1; i >= 0; i-- {
+			if done[i].previous == nil {
+				s.store.Delete(done[i].id)
+			} else {
+				s.store.Update(done[i].id, *done[i].previous)
+			}
+		}
+		report.Failed = len(rows)
+		report.Errors = append(report.Errors, importError{Row: row.row, Id: row.article.Id, Error: err.Error()})
+		report.Aborted = true
+		return
+	}
+
+	report.Imported = len(done)
+	for _, d := range done {
+		report.Ids = append(report.Ids, d.id)
+	}
+}
+
+// checkImport validates a row without writing anything
+func (s *server) checkImport(article Article) error {
+	if strings.TrimSpace(article.Title) == "" {
+		return errors.New("title is required")
+	}
+	// a row for an existing article changes its status like a PUT, importArticle checks it again when writing
+	var current *Article
+	if stored, err := s.store.Get(article.Id); article.Id != "" && err == nil {
+		current = &stored
+		keepTranslations(stored, &article)
+	}
+	if err := normalizeLocales(&article); err != nil {
+		return err
+	}
+	if err := applyWorkflow(current, &article, time.Now().UTC()); err != nil {
+		return err
+	}
+	if article.Author != nil {
+		if strings.TrimSpace(article.Author.Email) == "" {
+			return ErrAuthorInvalid
+		}
+		return nil
+	}
+	if article.AuthorId != "" {
+		return s.authors.check(article.AuthorId)
+	}
+	return nil
+}
+
+// importArticle writes one row and returns its id and, for an update, the article it replaced
+func (s *server) importArticle(r *http.Request, article Article) (string, *Article, error) {
+	normalizeTaxonomy(&article)
+	if err := s.resolveAuthor(&article); err != nil {
+		return "", nil, err
+	}
+	createdAt, now := article.CreatedAt, time.Now().UTC()
+
+	if article.Id != "" {
+		// an update is a write like a PUT: the preconditions and the status transitions apply to it
+		var previous Article
+		_, err := s.store.UpdateIf(article.Id, func(current Article) (Article, error) {
+			if err := s.checkIfMatch(r, current); err != nil {
+				return Article{}, err
+			}
+			if err := applyWorkflow(&current, &article, now); err != nil {
+				return Article{}, err
+			}
+			if createdAt.IsZero() {
+				createdAt = current.CreatedAt
+			}
+			keepTranslations(current, &article) // the CSV has no columns for them
+			if err := normalizeLocales(&article); err != nil {
+				return Article{}, err
+			}
+			article.Slug = s.slugs.claim(article.Id, article.Title)
+			stampEdit(r, &article, createdAt)
+			previous = current
+			return article, nil
+		})
+		if err == nil {
+			return article.Id, &previous, nil
+		}
+		s.slugs.release(article.Id)
+		if err != ErrArticleNotFound {
+			return "", nil, err
+		}
+	}
+
+	if err := normalizeLocales(&article); err != nil {
+		return "", nil, err
+	}
+	if err := applyWorkflow(nil, &article, now); err != nil {
+		return "", nil, err
+	}
+	if article.Id != "" {
+		article.Slug = s.slugs.claim(article.Id, article.Title)
+		stampEdit(r, &article, createdAt)
+		created, err := s.store.Create(article)
+		if err != nil {
+			s.slugs.release(article.Id)
+			return "", nil, err
+		}
+		return created.Id, nil, nil
+	}
+
+	stampEdit(r, &article, createdAt)
+	created, err := s.createWithNewID(article)
+	if err != nil {
+		return "", nil, err
+	}
+	return created.Id, nil, nil
+}
+
+// readNDJSON sends one row per non-empty line and closes rows when the body is done
+func readNDJSON(body io.Reader, rows chan<- importRow) error {
+	defer close(rows)
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		row := importRow{row: line}
+		row.err = json.Unmarshal([]byte(text), &row.article)
+		rows <- row
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading line %d: %v", line+1, err)
+	}
+	return nil
+}
+
+// readCSV sends one row per record after the header and closes rows when the body is done
+func readCSV(body io.Reader, rows chan<- importRow) error {
+	defer close(rows)
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("reading the CSV header: %v", err)
+	}
+	columns := make([]int, len(header)) // header position -> index in csvColumns
+	for i, name := range header {
+		columns[i] = -1
+		for j, known := range csvColumns {
+			if strings.EqualFold(strings.TrimSpace(name), known) {
+				columns[i] = j
+			}
+		}
+		if columns[i] < 0 {
+			return fmt.Errorf("unknown CSV column %q", name)
+		}
+	}
+
+	for n := 1; ; n++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		row := importRow{row: n}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return err
+			}
+			row.err = err
+			rows <- row
+			continue
+		}
+		if len(record) != len(header) {
+			row.err = fmt.Errorf("expected %d fields, got %d", len(header), len(record))
+			rows <- row
+			continue
+		}
+		row.article, row.err = csvArticle(columns, record)
+		rows <- row
+	}
+}
+
+func csvArticle(columns []int, record []string) (Article, error) {
+	var a Article
+	for i, value := range record {
+		var err error
+		switch csvColumns[columns[i]] {
+		case "Id":
+			a.Id = value
+		case "title":
+			a.Title = value
+		case "desc":
+			a.Desc = value
+		case "content":
+			a.Content = value
+		case "authorId":
+			a.AuthorId = value
+		case "category":
+			a.Category = value
+		case "tags":
+			if value != "" {
+				a.Tags = strings.Split(value, csvTagSeparator)
+			}
+		case "status":
+			a.Status = value
+		case "publishAt":
+			var t time.Time
+			if t, err = parseOptionalTime(value); err == nil && !t.IsZero() {
+				a.PublishAt = &t
+			}
+		case "createdAt":
+			a.CreatedAt, err = parseOptionalTime(value)
+		case "updatedAt":
+			a.UpdatedAt, err = parseOptionalTime(value)
+		}
+		if err != nil {
+			return a, err
+		}
+	}
+	return a, nil
+}
+
+func parseOptionalTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
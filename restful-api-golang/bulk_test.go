@@ -0,0 +1,94 @@
+// bulk_test.go
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestImportUpdateNeedsPrecondition(t *testing.T) {
+	s := newTestServer(t, newMemoryStore(seedArticles()...))
+	s.requirePreconditions = true
+	h := newRouter(s)
+
+	row := `{"Id": "1", "title": "Imported over article 1"}`
+	var report importReport
+	decode(t, do(h, "POST", "/articles/import", row, "Content-Type", "application/x-ndjson"), &report)
+	if report.Imported != 0 || report.Failed != 1 {
+		t.Errorf("an update without If-Match went through on a server that requires it: %+v", report)
+	}
+	if a, _ := s.store.Get("1"); a.Title != "Hello" {
+		t.Errorf("article 1 was overwritten to %q", a.Title)
+	}
+
+	// rows that create are not affected
+	decode(t, do(h, "POST", "/articles/import", `{"Id": "new", "title": "New"}`, "Content-Type", "application/x-ndjson"), &report)
+	if report.Imported != 1 {
+		t.Errorf("a new article was not imported: %+v", report)
+	}
+
+	etag := do(h, "GET", "/article/1", "").Header().Get("ETag")
+	decode(t, do(h, "POST", "/articles/import", row, "Content-Type", "application/x-ndjson", "If-Match", `"stale"`), &report)
+	if report.Imported != 0 {
+		t.Errorf("an update with a stale If-Match went through: %+v", report)
+	}
+	w := do(h, "POST", "/articles/import", row, "Content-Type", "application/x-ndjson", "If-Match", etag)
+	decode(t, w, &report)
+	if w.Code != http.StatusOK || report.Imported != 1 {
+		t.Errorf("an update with the current ETag was refused: %d %+v", w.Code, report)
+	}
+}
+
+// TestImportCannotSkipReview - a row for an existing draft changes its status like a PUT would
+func TestImportCannotSkipReview(t *testing.T) {
+	s := newTestServer(t, newMemoryStore())
+	h := newRouter(s)
+	var draft Article
+	decode(t, do(h, "POST", "/article", `{"title": "Draft", "status": "draft"}`), &draft)
+
+	var report importReport
+	for _, atomic := range []string{"false", "true"} {
+		row := `{"Id": "` + draft.Id + `", "title": "Published by import", "status": "published"}`
+		decode(t, do(h, "POST", "/articles/import?atomic="+atomic, row, "Content-Type", "application/x-ndjson"), &report)
+		if report.Imported != 0 {
+			t.Errorf("atomic=%s: the import published a draft: %+v", atomic, report)
+		}
+	}
+	if a, _ := s.store.Get(draft.Id); a.Status != StatusDraft {
+		t.Errorf("draft is %s after the import, want draft", a.Status)
+	}
+
+	// without a status the row keeps the one of the article
+	decode(t, do(h, "POST", "/articles/import", `{"Id": "`+draft.Id+`", "title": "Edited"}`, "Content-Type", "application/x-ndjson"), &report)
+	if a, _ := s.store.Get(draft.Id); report.Imported != 1 || a.Status != StatusDraft || a.Title != "Edited" {
+		t.Errorf("an edit by import gave %+v (%+v), want the edited draft", a, report)
+	}
+}
+
+// TestImportNormalizesLocales - rows go through the same locale checks as a POST or PUT
+func TestImportNormalizesLocales(t *testing.T) {
+	s := newTestServer(t, newMemoryStore())
+	h := newRouter(s)
+	rows := `{"Id": "a", "title": "Bad", "locale": "not a locale!"}
+{"Id": "b", "title": "Own", "locale": "vi-VN", "translations": {"vi-vn": {"title": "Xin chào"}}}
+{"Id": "c", "title": "Good", "locale": "en-us", "translations": {"vi-vn": {"title": "Xin chào"}}}
+`
+	var report importReport
+	decode(t, do(h, "POST", "/articles/import", rows, "Content-Type", "application/x-ndjson"), &report)
+	if report.Imported != 1 || report.Failed != 2 {
+		t.Fatalf("got %+v, want the rows with a bad locale and a translation in the own locale refused", report)
+	}
+	a, err := s.store.Get("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.Translations["vi-VN"]; a.Locale != "en-US" || !ok {
+		t.Errorf("imported locale %q and translations %v, want them canonical", a.Locale, a.Translations)
+	}
+
+	// an update of c in its translation's locale is refused when written, not only when checked
+	decode(t, do(h, "POST", "/articles/import", `{"Id": "c", "title": "Good", "locale": "vi-VN"}`, "Content-Type", "application/x-ndjson"), &report)
+	if report.Imported != 0 {
+		t.Errorf("an update that makes a translation the article's own locale was imported: %+v", report)
+	}
+}
@@ -0,0 +1,426 @@
+// collab.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	collabSaveDelay  = 2 * time.Second // quiet time before the edited content is written to the store
+	collabHistory    = 1000            // operations a session remembers, a client further behind has to reconnect
+	collabSendBuffer = 64              // messages queued for a client before it counts as gone
+	collabMaxMessage = 1 << 20
+)
+
+var collabUpgrader = websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096}
+
+// collabMessage - every message of the /article/{id}/collab protocol, in both directions.
+//
+//	server -> client: init    {rev, client, content, editors} right after connecting
+//	client -> server: op      {rev, op} an edit made on top of revision rev
+//	server -> client: ack     {rev} the own op is in, it made revision rev
+//	server -> client: op      {rev, op, client} someone else's op, client is empty for writes through the API
+//	client -> server: cursor  {cursor}
+//	server -> client: presence {editors} someone joined, left or moved their cursor
+//	server -> client: error   {error} the last message was refused
+//	server -> client: closed  {error} the article was deleted, the session is over
+type collabMessage struct {
+	Type    string         `json:"type"`
+	Rev     int            `json:"rev"`
+	Client  string         `json:"client,omitempty"`
+	Op      textOp         `json:"op,omitempty"`
+	Content *string        `json:"content,omitempty"`
+	Cursor  *int           `json:"cursor,omitempty"`
+	Editors []collabEditor `json:"editors,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// collabEditor - the presence entry of a connected client
+type collabEditor struct {
+	Client string    `json:"client"`
+	Editor string    `json:"editor"`
+	Cursor int       `json:"cursor"`
+	Since  time.Time `json:"since"`
+}
+
+type collabClient struct {
+	collabEditor
+	conn *websocket.Conn
+	send chan collabMessage
+}
+
+// collabSession - the editors of one article and the shared text.
+// Operations are put in order by the session (central server OT): an op based on an older
+// revision is transformed over the ops applied since, then applied and passed on to the others.
+type collabSession struct {
+	hub  *collabHub
+	id   string
+	mu   sync.Mutex
+	text string
+	rev  int
+	// history[i] turned revision base+i into base+i+1
+	history []textOp
+	base    int
+	clients map[*collabClient]bool
+
+	dirty      bool
+	timer      *time.Timer
+	saving     string // the content save is writing, recognized (and cleared) when it comes back through articleSaved
+	lastEditor string
+}
+
+// collabHub keeps the open sessions. It is a storeListener: a write through the API
+// reaches the editors as an op, a delete ends the session.
+type collabHub struct {
+	store    *notifyingStore
+	mu       sync.Mutex
+	sessions map[string]*collabSession
+	clients  uint64
+}
+
+func newCollabHub() *collabHub {
+	return &collabHub{sessions: make(map[string]*collabSession)}
+}
+
+// join returns the session of the article and adds a client to it
+func (h *collabHub) join(article Article, editor string, conn *websocket.Conn) (*collabSession, *collabClient) {
+	c := &collabClient{
+		collabEditor: collabEditor{
+			Client: fmt.Sprintf("c%d", atomic.AddUint64(&h.clients, 1)),
+			Editor: editor,
+			Since:  time.Now().UTC(),
+		},
+		conn: conn,
+		send: make(chan collabMessage, collabSendBuffer),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	session, ok := h.sessions[article.Id]
+	if !ok {
+		session = &collabSession{hub: h, id: article.Id, text: article.Content, clients: map[*collabClient]bool{}}
+		h.sessions[article.Id] = session
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.clients[c] = true
+	content := session.text
+	session.deliver(c, collabMessage{Type: "init", Client: c.Client, Content: &content, Editors: session.editors()})
+	session.broadcast(nil, collabMessage{Type: "presence", Editors: session.editors()})
+	return session, c
+}
+
+// leave removes a client, the last one out saves the text and closes the session
+func (h *collabHub) leave(session *collabSession, c *collabClient) {
+	session.mu.Lock()
+	if session.clients[c] {
+		delete(session.clients, c)
+		close(c.send)
+	}
+	empty := len(session.clients) == 0
+	if !empty {
+		session.broadcast(nil, collabMessage{Type: "presence", Editors: session.editors()})
+	}
+	session.mu.Unlock()
+	if !empty {
+		return
+	}
+
+	session.save()
+	h.mu.Lock()
+	session.mu.Lock()
+	// someone may have joined while it was saving
+	if len(session.clients) == 0 && h.sessions[session.id] == session {
+		delete(h.sessions, session.id)
+		if session.timer != nil {
+			session.timer.Stop()
+		}
+	}
+	session.mu.Unlock()
+	h.mu.Unlock()
+}
+
+func (h *collabHub) session(id string) *collabSession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessions[id]
+}
+
+func (h *collabHub) articleSaved(article Article) {
+	if session := h.session(article.Id); session != nil {
+		session.external(article.Content)
+	}
+}
+
+func (h *collabHub) articleDeleted(id string) {
+	h.mu.Lock()
+	session := h.sessions[id]
+	delete(h.sessions, id)
+	h.mu.Unlock()
+	if session != nil {
+		session.end("the article was deleted")
+	}
+}
+
+// editors - the presence list, must be called with session.mu held
+func (session *collabSession) editors() []collabEditor {
+	editors := make([]collabEditor, 0, len(session.clients))
+	for c := range session.clients {
+		editors = append(editors, c.collabEditor)
+	}
+	sort.Slice(editors, func(i, j int) bool {
+		if !editors[i].Since.Equal(editors[j].Since) {
+			return editors[i].Since.Before(editors[j].Since)
+		}
+		return editors[i].Client < editors[j].Client
+	})
+	return editors
+}
+
+// deliver queues a message for one client, must be called with session.mu held.
+// A client that does not keep up is disconnected instead of holding up the session.
+func (session *collabSession) deliver(c *collabClient, m collabMessage) {
+	if !session.clients[c] {
+		return // gone already, its channel is closed
+	}
+	m.Rev = session.rev
+	select {
+	case c.send <- m:
+	default:
+		c.conn.Close()
+	}
+}
+
+// refuse answers a message of c with an error
+func (session *collabSession) refuse(c *collabClient, reason string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.deliver(c, collabMessage{Type: "error", Error: reason})
+}
+
+// broadcast delivers to every client but except, must be called with session.mu held
+func (session *collabSession) broadcast(except *collabClient, m collabMessage) {
+	for c := range session.clients {
+		if c != except {
+			session.deliver(c, m)
+		}
+	}
+}
+
+// apply makes op the next revision, must be called with session.mu held.
+// from is nil for ops that did not come from a client.
+func (session *collabSession) apply(op textOp, text string, from *collabClient) {
+	session.text = text
+	session.rev++
+	session.history = append(session.history, op)
+	if len(session.history) > collabHistory {
+		drop := len(session.history) - collabHistory
+		session.history = append([]textOp(nil), session.history[drop:]...)
+		session.base += drop
+	}
+	for c := range session.clients {
+		c.Cursor = transformIndex(c.Cursor, op)
+	}
+
+	m := collabMessage{Type: "op", Op: op}
+	if from != nil {
+		m.Client = from.Client
+		session.deliver(from, collabMessage{Type: "ack"})
+	}
+	session.broadcast(from, m)
+}
+
+// receive takes an op a client made on top of revision rev
+func (session *collabSession) receive(c *collabClient, rev int, op textOp) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if rev < session.base || rev > session.rev {
+		session.deliver(c, collabMessage{Type: "error", Error: fmt.Sprintf("revision %d is not known here, reconnect to start over", rev)})
+		return
+	}
+	var err error
+	for _, concurrent := range session.history[rev-session.base:] {
+		if _, op, err = transform(concurrent, op); err != nil {
+			break
+		}
+	}
+	text := ""
+	if err == nil {
+		text, err = op.apply(session.text)
+	}
+	if err != nil {
+		session.deliver(c, collabMessage{Type: "error", Error: err.Error()})
+		return
+	}
+
+	session.apply(op, text, c)
+	session.lastEditor = c.Editor
+	session.dirty = true
+	if session.timer == nil {
+		session.timer = time.AfterFunc(collabSaveDelay, func() {
+			session.mu.Lock()
+			session.timer = nil
+			session.mu.Unlock()
+			session.save()
+		})
+	}
+}
+
+func (session *collabSession) moveCursor(c *collabClient, pos int) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if n := len([]rune(session.text)); pos < 0 || pos > n {
+		session.deliver(c, collabMessage{Type: "error", Error: fmt.Sprintf("cursor must be between 0 and %d", n)})
+		return
+	}
+	c.Cursor = pos
+	session.broadcast(nil, collabMessage{Type: "presence", Editors: session.editors()})
+}
+
+// external brings in content written to the store by someone outside the session
+func (session *collabSession) external(content string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.saving != "" && content == session.saving {
+		session.saving = "" // the own save coming back, the same content written later is someone else's
+		return
+	}
+	if content == session.text {
+		return
+	}
+	session.apply(diffOp(session.text, content), content, nil)
+	session.dirty = false // the store has the text now
+}
+
+// save writes the text to the store when it changed since the last save
+func (session *collabSession) save() {
+	session.mu.Lock()
+	if !session.dirty {
+		session.mu.Unlock()
+		return
+	}
+	text, editor := session.text, session.lastEditor
+	session.saving, session.dirty = text, false
+	session.mu.Unlock()
+
+	_, err := session.hub.store.UpdateIf(session.id, func(current Article) (Article, error) {
+		updated := current.clone()
+		updated.Content = text
+		updated.UpdatedAt, updated.UpdatedBy = time.Now().UTC(), editor
+		return updated, nil
+	})
+	if err != nil && err != ErrArticleNotFound {
+		log.Printf("collab: unable to save article %s: %v", session.id, err)
+		session.mu.Lock()
+		session.saving = ""
+		session.dirty = true // the next save tries again
+		session.mu.Unlock()
+	}
+}
+
+// end tells every client the session is over and lets them go
+func (session *collabSession) end(reason string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	session.broadcast(nil, collabMessage{Type: "closed", Error: reason})
+	for c := range session.clients {
+		delete(session.clients, c)
+		close(c.send)
+	}
+	session.dirty = false
+	if session.timer != nil {
+		session.timer.Stop()
+	}
+}
+
+// writeLoop sends the queued messages, gorilla/websocket allows one writer per connection
+func (c *collabClient) writeLoop() {
+	defer c.conn.Close()
+	for m := range c.send {
+		if err := c.conn.WriteJSON(m); err != nil {
+			return
+		}
+	}
+	c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+// collabEditorOf - who is editing: X-Editor like the other writes, or ?editor= as browsers cannot set headers on a WebSocket
+func collabEditorOf(r *http.Request) string {
+	if editor := strings.TrimSpace(r.Header.Get("X-Editor")); editor != "" {
+		return editor
+	}
+	if editor := strings.TrimSpace(r.URL.Query().Get("editor")); editor != "" {
+		return editor
+	}
+	return "anonymous"
+}
+
+// GET /article/{id}/collab - WebSocket for editing the content together, see collabMessage for the protocol.
+// Joining is the start of a write: it needs If-Match (or ?ifMatch=, for browsers) under -require-if-match,
+// and an unpublished article needs ?preview=true as for reading it.
+func (s *server) collabArticle(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: collabArticle")
+	id := mux.Vars(r)["id"]
+
+	article, err := s.visibleArticle(r, id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if v := r.URL.Query().Get("ifMatch"); v != "" && r.Header.Get("If-Match") == "" {
+		r.Header.Set("If-Match", v)
+	}
+	if err := s.checkIfMatch(r, article); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	conn, err := collabUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade has answered already
+	}
+	conn.SetReadLimit(collabMaxMessage)
+
+	session, c := s.collab.join(article, collabEditorOf(r), conn)
+	go c.writeLoop()
+	defer s.collab.leave(session, c)
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return // closed by the client, or by deliver or end
+		}
+		var m collabMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			session.refuse(c, err.Error())
+			continue
+		}
+		switch m.Type {
+		case "op":
+			session.receive(c, m.Rev, m.Op)
+		case "cursor":
+			if m.Cursor == nil {
+				session.refuse(c, "a cursor message needs a cursor")
+				continue
+			}
+			session.moveCursor(c, *m.Cursor)
+		default:
+			session.refuse(c, fmt.Sprintf("unknown message type %q", m.Type))
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// collab_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialCollab opens the collab WebSocket of an article on srv, header is a list of name, value pairs
+func dialCollab(t *testing.T, srv *httptest.Server, path string, header ...string) (*websocket.Conn, int) {
+	t.Helper()
+	h := http.Header{}
+	for i := 0; i+1 < len(header); i += 2 {
+		h.Set(header[i], header[i+1])
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path, h)
+	if err != nil {
+		if resp == nil {
+			t.Fatal(err)
+		}
+		return nil, resp.StatusCode
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, resp.StatusCode
+}
+
+// readUntil reads messages from conn until one of type typ arrives
+func readUntil(t *testing.T, conn *websocket.Conn, typ string) collabMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var m collabMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatalf("waiting for a %s message: %v", typ, err)
+		}
+		if m.Type == typ {
+			return m
+		}
+	}
+}
+
+func TestCollabChecksVisibilityAndPreconditions(t *testing.T) {
+	s := newTestServer(t, newMemoryStore(seedArticles()...))
+	srv := httptest.NewServer(newRouter(s))
+	defer srv.Close()
+	h := newRouter(s)
+	var draft Article
+	decode(t, do(h, "POST", "/article", `{"title": "Draft", "status": "draft"}`), &draft)
+
+	if _, status := dialCollab(t, srv, "/article/"+draft.Id+"/collab"); status != http.StatusNotFound {
+		t.Errorf("joining a draft: got %d, want 404", status)
+	}
+	if conn, _ := dialCollab(t, srv, "/article/"+draft.Id+"/collab?preview=true"); conn == nil {
+		t.Error("joining a draft with ?preview=true was refused")
+	}
+
+	s.requirePreconditions = true
+	if _, status := dialCollab(t, srv, "/article/1/collab"); status != http.StatusPreconditionRequired {
+		t.Errorf("joining without If-Match: got %d, want 428", status)
+	}
+	if _, status := dialCollab(t, srv, "/article/1/collab", "If-Match", `"stale"`); status != http.StatusPreconditionFailed {
+		t.Errorf("joining with a stale If-Match: got %d, want 412", status)
+	}
+	etag := do(h, "GET", "/article/1", "").Header().Get("ETag")
+	if conn, _ := dialCollab(t, srv, "/article/1/collab", "If-Match", etag); conn == nil {
+		t.Error("joining with the current ETag was refused")
+	}
+	if conn, _ := dialCollab(t, srv, "/article/1/collab?ifMatch="+url.QueryEscape(etag)); conn == nil {
+		t.Error("joining with the current ETag in ?ifMatch= was refused")
+	}
+}
+
+// TestCollabSeesRepeatedExternalWrite - once the session's own save has come back,
+// a write through the API with the same content is someone else's and reaches the editors
+func TestCollabSeesRepeatedExternalWrite(t *testing.T) {
+	s := newTestServer(t, newMemoryStore(seedArticles()...))
+	srv := httptest.NewServer(newRouter(s))
+	defer srv.Close()
+	conn, _ := dialCollab(t, srv, "/article/1/collab")
+	start := readUntil(t, conn, "init")
+
+	// "Article Content" -> "A", saved, then -> "B"
+	send := func(rev int, op textOp) {
+		t.Helper()
+		if err := conn.WriteJSON(collabMessage{Type: "op", Rev: rev, Op: op}); err != nil {
+			t.Fatal(err)
+		}
+		readUntil(t, conn, "ack")
+	}
+	send(start.Rev, diffOp(*start.Content, "A"))
+	s.collab.session("1").save()
+	if a, _ := s.store.Get("1"); a.Content != "A" {
+		t.Fatalf("the session saved %q, want A", a.Content)
+	}
+	send(start.Rev+1, diffOp("A", "B"))
+
+	if w := do(newRouter(s), "PUT", "/article/1", `{"title": "Hello", "content": "A"}`); w.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", w.Code, w.Body)
+	}
+	m := readUntil(t, conn, "op")
+	if got, err := m.Op.apply("B"); err != nil || got != "A" || m.Client != "" {
+		t.Errorf("the editors got %v from %q (%q, %v), want the write through the API", m.Op, m.Client, got, err)
+	}
+}
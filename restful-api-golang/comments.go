@@ -0,0 +1,430 @@
+// comments.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	ErrCommentNotFound = errors.New("comment not found")
+	ErrCommentExists   = errors.New("comment already exists")
+	ErrCommentEmpty    = errors.New("comment needs a body")
+	ErrCommentAuthor   = errors.New("comment needs an authorId or an author")
+	ErrParentNotFound  = errors.New("parentId is not a comment on this article")
+)
+
+// Comment - a comment under an article, ParentId makes it a reply to another comment
+type Comment struct {
+	Id        string  `json:"Id"`
+	ArticleId string  `json:"articleId"`
+	ParentId  string  `json:"parentId,omitempty"`
+	Body      string  `json:"body"`
+	AuthorId  string  `json:"authorId"`
+	Author    *Author `json:"author,omitempty"` // filled in from the author store when the comment is served
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Replies []Comment `json:"replies,omitempty"` // only set when a thread is served
+}
+
+// commentStore keeps the comments of all articles in one list, oldest first.
+// It is a storeListener: deleting an article deletes its comments too.
+type commentStore struct {
+	mu       sync.RWMutex
+	comments []Comment
+	path     string
+}
+
+// newCommentStore loads the comments saved at path, an empty path keeps them in memory only
+func newCommentStore(path string) (*commentStore, error) {
+	c := &commentStore{path: path}
+	if path != "" {
+		if err := loadJSONFile(path, &c.comments); err != nil {
+			return nil, fmt.Errorf("comments %s: %v", path, err)
+		}
+	}
+	return c, nil
+}
+
+// save must be called with c.mu held
+func (c *commentStore) save() error {
+	if c.path == "" {
+		return nil
+	}
+	return saveJSONFile(c.path, c.comments)
+}
+
+// indexOf must be called with c.mu held
+func (c *commentStore) indexOf(id string) int {
+	for index, comment := range c.comments {
+		if comment.Id == id {
+			return index
+		}
+	}
+	return -1
+}
+
+// keep replaces the comments with those keep accepts and saves them, must be called with c.mu held
+func (c *commentStore) keep(keep func(comment Comment) bool) (int, error) {
+	var kept []Comment
+	for _, comment := range c.comments {
+		if keep(comment) {
+			kept = append(kept, comment)
+		}
+	}
+	removed := len(c.comments) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	old := c.comments
+	c.comments = kept
+	if err := c.save(); err != nil {
+		c.comments = old
+		return 0, err
+	}
+	return removed, nil
+}
+
+func (c *commentStore) Get(id string) (Comment, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	index := c.indexOf(id)
+	if index < 0 {
+		return Comment{}, ErrCommentNotFound
+	}
+	return c.comments[index], nil
+}
+
+// ForArticle returns the comments of one article, oldest first
+// List returns the comments of all articles, oldest first
+func (c *commentStore) List() []Comment {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]Comment(nil), c.comments...)
+}
+
+func (c *commentStore) ForArticle(articleId string) []Comment {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var comments []Comment
+	for _, comment := range c.comments {
+		if comment.ArticleId == articleId {
+			comments = append(comments, comment)
+		}
+	}
+	return comments
+}
+
+func (c *commentStore) Create(comment Comment) (Comment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.indexOf(comment.Id) >= 0 {
+		return Comment{}, ErrCommentExists
+	}
+	if comment.ParentId != "" {
+		parent := c.indexOf(comment.ParentId)
+		if parent < 0 || c.comments[parent].ArticleId != comment.ArticleId {
+			return Comment{}, ErrParentNotFound
+		}
+	}
+	comment.Author, comment.Replies = nil, nil
+	c.comments = append(c.comments, comment)
+	if err := c.save(); err != nil {
+		c.comments = c.comments[:len(c.comments)-1]
+		return Comment{}, err
+	}
+	return comment, nil
+}
+
+// UpdateIf writes what change returns for the current comment, see notifyingStore.UpdateIf
+func (c *commentStore) UpdateIf(id string, change func(current Comment) (Comment, error)) (Comment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index := c.indexOf(id)
+	if index < 0 {
+		return Comment{}, ErrCommentNotFound
+	}
+	current := c.comments[index]
+	updated, err := change(current)
+	if err != nil {
+		return Comment{}, err
+	}
+	updated.Id, updated.ArticleId, updated.ParentId = current.Id, current.ArticleId, current.ParentId
+	updated.Author, updated.Replies = nil, nil
+	c.comments[index] = updated
+	if err := c.save(); err != nil {
+		c.comments[index] = current
+		return Comment{}, err
+	}
+	return updated, nil
+}
+
+// Delete removes the comment together with all replies below it and returns how many went
+func (c *commentStore) Delete(id string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.indexOf(id) < 0 {
+		return 0, ErrCommentNotFound
+	}
+	doomed := map[string]bool{id: true}
+	// replies always come after their parent, so one pass finds the whole subtree
+	for _, comment := range c.comments {
+		if doomed[comment.ParentId] {
+			doomed[comment.Id] = true
+		}
+	}
+	return c.keep(func(comment Comment) bool { return !doomed[comment.Id] })
+}
+
+// restore puts back the comments of an article that came out of the trash
+func (c *commentStore) restore(comments []Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old := c.comments
+	for _, comment := range comments {
+		if c.indexOf(comment.Id) < 0 {
+			c.comments = append(c.comments, comment)
+		}
+	}
+	if err := c.save(); err != nil {
+		c.comments = old
+		return err
+	}
+	return nil
+}
+
+func (c *commentStore) articleSaved(article Article) {}
+
+func (c *commentStore) articleDeleted(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.keep(func(comment Comment) bool { return comment.ArticleId != id }); err != nil {
+		log.Printf("comments: unable to delete the comments of article %s: %v", id, err)
+	}
+}
+
+// threads nests the replies under their parents and returns the top level comments, oldest first
+func threads(comments []Comment) []Comment {
+	children := make(map[string][]Comment)
+	for _, comment := range comments {
+		children[comment.ParentId] = append(children[comment.ParentId], comment)
+	}
+	var nest func(parentId string) []Comment
+	nest = func(parentId string) []Comment {
+		level := children[parentId]
+		for i := range level {
+			level[i].Replies = nest(level[i].Id)
+		}
+		return level
+	}
+	return nest("")
+}
+
+// presentComment fills in the authors of a comment and all its replies
+func (s *server) presentComment(comment Comment) Comment {
+	comment.Author = nil
+	if author, err := s.authors.Get(comment.AuthorId); err == nil {
+		comment.Author = &author
+	}
+	for i, reply := range comment.Replies {
+		comment.Replies[i] = s.presentComment(reply)
+	}
+	return comment
+}
+
+// writeCommentError maps the comment errors onto HTTP status codes, the rest goes to writeStoreError
+func writeCommentError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrCommentNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case ErrCommentEmpty, ErrCommentAuthor, ErrParentNotFound:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeStoreError(w, err)
+	}
+}
+
+// commentOf returns the comment only when it belongs to the article in the path
+func (s *server) commentOf(articleId, commentId string) (Comment, error) {
+	if _, err := s.store.Get(articleId); err != nil {
+		return Comment{}, err
+	}
+	comment, err := s.comments.Get(commentId)
+	if err != nil || comment.ArticleId != articleId {
+		return Comment{}, ErrCommentNotFound
+	}
+	return comment, nil
+}
+
+// GET /article/{id}/comments - the threads under an article, ?limit and ?offset page over the top level comments
+func (s *server) returnArticleComments(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: returnArticleComments")
+	id := mux.Vars(r)["id"]
+	if _, err := s.visibleArticle(r, id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	limit, offset, err := parseLimitOffset(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	roots := threads(s.comments.ForArticle(id))
+	page := listPage{total: len(roots)}
+	start, end := offset, len(roots)
+	if start > end {
+		start = end
+	}
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	writePageHeaders(w, r, listQuery{limit: limit, offset: offset}, page)
+
+	served := make([]Comment, 0, end-start)
+	for _, comment := range roots[start:end] {
+		served = append(served, s.presentComment(comment))
+	}
+	render(w, r, served)
+}
+
+// POST /article/{id}/comments - body {"body": ..., "authorId": ..., "parentId": ...}, an embedded author is created like for articles
+func (s *server) createComment(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := s.visibleArticle(r, id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var comment Comment
+	if err := decodeBody(r, &comment); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if strings.TrimSpace(comment.Body) == "" {
+		writeCommentError(w, ErrCommentEmpty)
+		return
+	}
+	// the author reference works the same as on an article
+	ref := Article{AuthorId: comment.AuthorId, Author: comment.Author}
+	if err := s.resolveAuthor(&ref); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ref.AuthorId == "" {
+		writeCommentError(w, ErrCommentAuthor)
+		return
+	}
+
+	comment.ArticleId, comment.AuthorId = id, ref.AuthorId
+	comment.CreatedAt = time.Now().UTC()
+	comment.UpdatedAt = comment.CreatedAt
+	// the article is looked up again under the article write lock: deleting it in between
+	// takes its comments to the trash and would leave this one behind
+	err := s.store.locked(func() error {
+		if _, err := s.store.ArticleStore.Get(id); err != nil {
+			return err
+		}
+		var err error
+		for attempt := 0; attempt < maxIDAttempts; attempt++ {
+			if comment.Id, err = s.ids.NewID(); err != nil {
+				return err
+			}
+			var created Comment
+			if created, err = s.comments.Create(comment); err != ErrCommentExists {
+				comment = created
+				return err
+			}
+		}
+		return err
+	})
+	if err != nil {
+		writeCommentError(w, err)
+		return
+	}
+	renderStatus(w, r, http.StatusCreated, s.presentComment(comment))
+}
+
+func (s *server) returnComment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if _, err := s.visibleArticle(r, vars["id"]); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	comment, err := s.commentOf(vars["id"], vars["commentId"])
+	if err != nil {
+		writeCommentError(w, err)
+		return
+	}
+	render(w, r, s.presentComment(comment))
+}
+
+// PUT /article/{id}/comments/{commentId} - only the body of a comment can be edited
+func (s *server) updateComment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if _, err := s.visibleArticle(r, vars["id"]); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if _, err := s.commentOf(vars["id"], vars["commentId"]); err != nil {
+		writeCommentError(w, err)
+		return
+	}
+
+	var edit Comment
+	if err := decodeBody(r, &edit); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if strings.TrimSpace(edit.Body) == "" {
+		writeCommentError(w, ErrCommentEmpty)
+		return
+	}
+	comment, err := s.comments.UpdateIf(vars["commentId"], func(current Comment) (Comment, error) {
+		current.Body = edit.Body
+		current.UpdatedAt = time.Now().UTC()
+		return current, nil
+	})
+	if err != nil {
+		writeCommentError(w, err)
+		return
+	}
+	render(w, r, s.presentComment(comment))
+}
+
+// DELETE /article/{id}/comments/{commentId} - deletes the comment and the replies below it
+func (s *server) deleteComment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if _, err := s.visibleArticle(r, vars["id"]); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if _, err := s.commentOf(vars["id"], vars["commentId"]); err != nil {
+		writeCommentError(w, err)
+		return
+	}
+	if _, err := s.comments.Delete(vars["commentId"]); err != nil {
+		writeCommentError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
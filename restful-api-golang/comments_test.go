@@ -0,0 +1,48 @@
+// comments_test.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// TestDeleteArticleRacesComment - a comment posted while its article is deleted either
+// goes to the trash with the article or is refused, it is never left without an article
+func TestDeleteArticleRacesComment(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		s := newTestServer(t, newMemoryStore())
+		h := newRouter(s)
+		var created Article
+		decode(t, do(h, "POST", "/article", `{"title": "Post", "author": {"Name": "Ann", "Email": "ann@example.com"}}`), &created)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				path := "/article/" + created.Id + "/comments"
+				do(h, "POST", path, fmt.Sprintf(`{"body": "comment %d", "authorId": "ann@example.com"}`, i))
+			}(i)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do(h, "DELETE", "/article/"+created.Id, "")
+		}()
+		wg.Wait()
+
+		if left := s.comments.ForArticle(created.Id); len(left) > 0 {
+			t.Fatalf("round %d: %d comments left behind by the deleted article", round, len(left))
+		}
+	}
+}
+
+func TestCommentOnMissingArticle(t *testing.T) {
+	h := newRouter(newTestServer(t, newMemoryStore()))
+	w := do(h, "POST", "/article/42/comments", `{"body": "hello", "author": {"Name": "Ann", "Email": "ann@example.com"}}`)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("comment on a missing article: got %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,359 @@
+// engagement.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	engagementHours  = 7 * 24 // hourly counts kept per article, the longest trending window
+	engagementLike   = 5      // a like counts like this many views
+	defaultTrending  = 10
+	defaultWindow    = 24 * time.Hour
+	hourBits         = 40 // an hourly slot packs the hour above these bits and the count below
+	hourCountMask    = 1<<hourBits - 1
+	maxTrendingRange = engagementHours * time.Hour
+)
+
+// articleEngagement - the counters of one article, updated with atomics only.
+// Each hourly slot is one word holding the hour it counts and the count,
+// so moving a slot on to a new hour and counting in it is a single compare-and-swap.
+type articleEngagement struct {
+	views     int64 // all time
+	likes     int64
+	viewHours [engagementHours]uint64
+	likeHours [engagementHours]uint64
+	likedBy   sync.Map // client identity -> struct{}
+}
+
+// bump counts one event in the slot of hour
+func bump(slots *[engagementHours]uint64, hour int64) {
+	slot := &slots[hour%engagementHours]
+	for {
+		old := atomic.LoadUint64(slot)
+		next := uint64(hour)<<hourBits | 1
+		switch slotHour := int64(old >> hourBits); {
+		case slotHour == hour:
+			next = old + 1
+		case slotHour > hour:
+			return // the clock went back, the slot belongs to a later hour already
+		}
+		if atomic.CompareAndSwapUint64(slot, old, next) {
+			return
+		}
+	}
+}
+
+// countAt returns the count of hour, zero when its slot has moved on
+func countAt(slots *[engagementHours]uint64, hour int64) int64 {
+	v := atomic.LoadUint64(&slots[hour%engagementHours])
+	if int64(v>>hourBits) != hour {
+		return 0
+	}
+	return int64(v & hourCountMask)
+}
+
+func hourOf(t time.Time) int64 {
+	return t.Unix() / 3600
+}
+
+// engagementStore counts views and likes per article without taking locks on the request path.
+// The counters live in memory and are written to path by flushEvery, an empty path keeps them in memory only.
+// Counters stay after an article is deleted, so an article restored from the trash keeps them, see forget.
+type engagementStore struct {
+	articles sync.Map // article id -> *articleEngagement
+	dirty    int32    // set by every count, cleared by flush
+	flushMu  sync.Mutex
+	path     string
+}
+
+// savedEngagement - the file format of one article's counters
+type savedEngagement struct {
+	Views   int64        `json:"views"`
+	Likes   int64        `json:"likes"`
+	LikedBy []string     `json:"likedBy,omitempty"`
+	Hours   []savedHours `json:"hours,omitempty"`
+}
+
+type savedHours struct {
+	Hour  int64 `json:"hour"` // hours since the Unix epoch
+	Views int64 `json:"views,omitempty"`
+	Likes int64 `json:"likes,omitempty"`
+}
+
+// newEngagementStore loads the counters saved at path, an empty path keeps them in memory only
+func newEngagementStore(path string) (*engagementStore, error) {
+	e := &engagementStore{path: path}
+	if path == "" {
+		return e, nil
+	}
+	saved := map[string]savedEngagement{}
+	if err := loadJSONFile(path, &saved); err != nil {
+		return nil, fmt.Errorf("engagement %s: %v", path, err)
+	}
+	for id, s := range saved {
+		a := e.of(id)
+		a.views, a.likes = s.Views, s.Likes
+		for _, client := range s.LikedBy {
+			a.likedBy.Store(client, struct{}{})
+		}
+		for _, h := range s.Hours {
+			if h.Views > 0 {
+				a.viewHours[h.Hour%engagementHours] = uint64(h.Hour)<<hourBits | uint64(h.Views)
+			}
+			if h.Likes > 0 {
+				a.likeHours[h.Hour%engagementHours] = uint64(h.Hour)<<hourBits | uint64(h.Likes)
+			}
+		}
+	}
+	return e, nil
+}
+
+func (e *engagementStore) of(id string) *articleEngagement {
+	if a, ok := e.articles.Load(id); ok {
+		return a.(*articleEngagement)
+	}
+	a, _ := e.articles.LoadOrStore(id, &articleEngagement{})
+	return a.(*articleEngagement)
+}
+
+func (e *engagementStore) view(id string, now time.Time) {
+	a := e.of(id)
+	atomic.AddInt64(&a.views, 1)
+	bump(&a.viewHours, hourOf(now))
+	atomic.StoreInt32(&e.dirty, 1)
+}
+
+// like counts a like of client, it reports false when the client liked the article before
+func (e *engagementStore) like(id, client string, now time.Time) bool {
+	a := e.of(id)
+	if _, seen := a.likedBy.LoadOrStore(client, struct{}{}); seen {
+		return false
+	}
+	atomic.AddInt64(&a.likes, 1)
+	bump(&a.likeHours, hourOf(now))
+	atomic.StoreInt32(&e.dirty, 1)
+	return true
+}
+
+// forget drops the counters of a purged article, the next flush leaves them out
+func (e *engagementStore) forget(id string) {
+	if _, ok := e.articles.LoadAndDelete(id); ok {
+		atomic.StoreInt32(&e.dirty, 1)
+	}
+}
+
+// engagementCounts - the counters of an article as the API shows them
+type engagementCounts struct {
+	Views int64 `json:"views"`
+	Likes int64 `json:"likes"`
+}
+
+func (e *engagementStore) counts(id string) engagementCounts {
+	a, ok := e.articles.Load(id)
+	if !ok {
+		return engagementCounts{}
+	}
+	return engagementCounts{
+		Views: atomic.LoadInt64(&a.(*articleEngagement).views),
+		Likes: atomic.LoadInt64(&a.(*articleEngagement).likes),
+	}
+}
+
+// trend sums the views and likes of the window before now, each hour weighted by how long ago it was:
+// the weight halves every quarter of the window, so the last hours count the most
+func (e *engagementStore) trend(id string, window time.Duration, now time.Time) (float64, engagementCounts) {
+	v, ok := e.articles.Load(id)
+	if !ok {
+		return 0, engagementCounts{}
+	}
+	a := v.(*articleEngagement)
+	halfLife := window.Hours() / 4
+	current := hourOf(now)
+
+	score := 0.0
+	var counts engagementCounts
+	for age := int64(0); age < int64(window.Hours()); age++ {
+		views, likes := countAt(&a.viewHours, current-age), countAt(&a.likeHours, current-age)
+		if views == 0 && likes == 0 {
+			continue
+		}
+		counts.Views += views
+		counts.Likes += likes
+		score += float64(views+engagementLike*likes) * math.Pow(0.5, float64(age)/halfLife)
+	}
+	return score, counts
+}
+
+// flush writes the counters to path when they changed since the last flush
+func (e *engagementStore) flush() error {
+	if e.path == "" || !atomic.CompareAndSwapInt32(&e.dirty, 1, 0) {
+		return nil
+	}
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+
+	oldest := hourOf(time.Now()) - engagementHours
+	saved := map[string]savedEngagement{}
+	e.articles.Range(func(key, value interface{}) bool {
+		a := value.(*articleEngagement)
+		s := savedEngagement{Views: atomic.LoadInt64(&a.views), Likes: atomic.LoadInt64(&a.likes)}
+		a.likedBy.Range(func(client, _ interface{}) bool {
+			s.LikedBy = append(s.LikedBy, client.(string))
+			return true
+		})
+		sort.Strings(s.LikedBy)
+		hours := map[int64]*savedHours{}
+		add := func(slot uint64, likes bool) {
+			hour := int64(slot >> hourBits)
+			if slot == 0 || hour <= oldest {
+				return
+			}
+			h, ok := hours[hour]
+			if !ok {
+				h = &savedHours{Hour: hour}
+				hours[hour] = h
+			}
+			if likes {
+				h.Likes = int64(slot & hourCountMask)
+			} else {
+				h.Views = int64(slot & hourCountMask)
+			}
+		}
+		for i := range a.viewHours {
+			add(atomic.LoadUint64(&a.viewHours[i]), false)
+			add(atomic.LoadUint64(&a.likeHours[i]), true)
+		}
+		for _, h := range hours {
+			s.Hours = append(s.Hours, *h)
+		}
+		sort.Slice(s.Hours, func(i, j int) bool { return s.Hours[i].Hour < s.Hours[j].Hour })
+		saved[key.(string)] = s
+		return true
+	})
+	if err := saveJSONFile(e.path, saved); err != nil {
+		atomic.StoreInt32(&e.dirty, 1) // try again on the next tick
+		return err
+	}
+	return nil
+}
+
+// flushEvery runs in the background and writes the counters on every tick that saw a view or a like
+func (e *engagementStore) flushEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := e.flush(); err != nil {
+			log.Printf("engagement: unable to save the counters: %v", err)
+		}
+	}
+}
+
+// clientIdentity - who likes: the X-Client-Id header, else X-Editor, else the remote address
+func clientIdentity(r *http.Request) string {
+	for _, header := range []string{"X-Client-Id", "X-Editor"} {
+		if v := strings.TrimSpace(r.Header.Get(header)); v != "" {
+			return v
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// likeResult - the answer of POST /article/{id}/like
+type likeResult struct {
+	engagementCounts
+	Liked bool `json:"liked"` // false when this client had liked the article before
+}
+
+// POST /article/{id}/like - one like per client, liking again changes nothing
+func (s *server) likeArticle(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: likeArticle")
+	id := mux.Vars(r)["id"]
+
+	if _, err := s.visibleArticle(r, id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	liked := s.engagement.like(id, clientIdentity(r), time.Now())
+	render(w, r, likeResult{engagementCounts: s.engagement.counts(id), Liked: liked})
+}
+
+// trendingArticle - one entry of GET /articles/trending, the counts are those of the window
+type trendingArticle struct {
+	Article Article `json:"article"`
+	Score   float64 `json:"score"`
+	Views   int64   `json:"views"`
+	Likes   int64   `json:"likes"`
+}
+
+// GET /articles/trending?window=24h&limit=10 - the articles with the most recent views and likes
+func (s *server) returnTrendingArticles(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: returnTrendingArticles")
+	query := r.URL.Query()
+
+	window := defaultWindow
+	if v := query.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Hour || d > maxTrendingRange {
+			http.Error(w, fmt.Sprintf("window must be a duration between 1h and %dh", engagementHours), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+	limit := defaultTrending
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		if n > maxPageSize {
+			n = maxPageSize
+		}
+		limit = n
+	}
+
+	articles, err := s.store.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	results := []trendingArticle{}
+	for _, article := range articles {
+		if !isPublished(article) && !wantsUnpublished(r) {
+			continue
+		}
+		score, counts := s.engagement.trend(article.Id, window, now)
+		if score > 0 {
+			results = append(results, trendingArticle{Article: article, Score: score, Views: counts.Views, Likes: counts.Likes})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Article.Id < results[j].Article.Id
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	for i := range results {
+		results[i].Article = s.present(results[i].Article)
+	}
+	render(w, r, results)
+}
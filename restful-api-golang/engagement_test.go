@@ -0,0 +1,125 @@
+// engagement_test.go
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRepeatedLike - a client likes an article once, liking again changes nothing
+func TestRepeatedLike(t *testing.T) {
+	h := newRouter(newTestServer(t, newMemoryStore(seedArticles()...)))
+
+	for i, want := range []struct {
+		client string
+		liked  bool
+		likes  int64
+	}{
+		{"ann", true, 1},
+		{"ann", false, 1},
+		{"bea", true, 2},
+		{"ann", false, 2},
+	} {
+		w := do(h, "POST", "/article/1/like", "", "X-Client-Id", want.client)
+		if w.Code != http.StatusOK {
+			t.Fatalf("like %d: %d %s", i, w.Code, w.Body)
+		}
+		var result likeResult
+		decode(t, w, &result)
+		if result.Liked != want.liked || result.Likes != want.likes {
+			t.Errorf("like %d by %s: liked %v with %d likes, want %v with %d", i, want.client, result.Liked, result.Likes, want.liked, want.likes)
+		}
+	}
+}
+
+// TestTrendingOrder - recent views and likes weigh the most, what is older than the window is left out
+func TestTrendingOrder(t *testing.T) {
+	s := newTestServer(t, newMemoryStore(seedArticles()...))
+	h := newRouter(s)
+	var third Article
+	decode(t, do(h, "POST", "/article", `{"title": "Third"}`), &third)
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		s.engagement.view("1", now) // 3
+	}
+	s.engagement.like("2", "ann", now) // 5
+	for i := 0; i < 10; i++ {
+		s.engagement.view(third.Id, now.Add(-20*time.Hour)) // 10 halved every 6 hours
+	}
+
+	ids := func(path string) string {
+		t.Helper()
+		var trending []trendingArticle
+		decode(t, do(h, "GET", path, ""), &trending)
+		var ids []string
+		for _, entry := range trending {
+			ids = append(ids, entry.Article.Id)
+		}
+		return strings.Join(ids, ",")
+	}
+	if got, want := ids("/articles/trending"), "2,1,"+third.Id; got != want {
+		t.Errorf("trending: got %s, want %s", got, want)
+	}
+	if got, want := ids("/articles/trending?window=12h"), "2,1"; got != want {
+		t.Errorf("trending in 12h: got %s, want %s", got, want)
+	}
+	if got, want := ids("/articles/trending?limit=1"), "2"; got != want {
+		t.Errorf("trending with a limit of 1: got %s, want %s", got, want)
+	}
+}
+
+// TestViewAfterLocalize - a request refused for its locale is not counted as a view
+func TestViewAfterLocalize(t *testing.T) {
+	s := newTestServer(t, newMemoryStore(seedArticles()...))
+	h := newRouter(s)
+
+	if w := do(h, "GET", "/article/1?lang=%21%21", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("GET with a bad locale: got %d, want 400", w.Code)
+	}
+	if views := s.engagement.counts("1").Views; views != 0 {
+		t.Errorf("a refused request counted %d views", views)
+	}
+	do(h, "GET", "/article/1", "")
+	if views := s.engagement.counts("1").Views; views != 1 {
+		t.Errorf("got %d views after one GET, want 1", views)
+	}
+}
+
+// TestEngagementSurvivesRestart - the counters, the hours and who liked come back from the flushed file
+func TestEngagementSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engagement.json")
+	e, err := newEngagementStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	e.view("1", now)
+	e.view("1", now.Add(-2*time.Hour))
+	e.like("1", "ann", now)
+	e.like("2", "bea", now)
+	if err := e.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := newEngagementStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2"} {
+		if got, want := reopened.counts(id), e.counts(id); got != want {
+			t.Errorf("article %s after a restart: %+v, want %+v", id, got, want)
+		}
+		gotScore, gotCounts := reopened.trend(id, defaultWindow, now)
+		wantScore, wantCounts := e.trend(id, defaultWindow, now)
+		if gotScore != wantScore || gotCounts != wantCounts {
+			t.Errorf("trend of article %s after a restart: %v %+v, want %v %+v", id, gotScore, gotCounts, wantScore, wantCounts)
+		}
+	}
+	if reopened.like("1", "ann", now) {
+		t.Error("a client liked article 1 again after a restart")
+	}
+}
@@ -0,0 +1,106 @@
+// etag.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrPreconditionFailed   = errors.New("article was changed by someone else, If-Match does not match")
+	ErrPreconditionRequired = errors.New("this server requires an If-Match header on article writes")
+)
+
+// articleETag - strong ETag over the stored article, so every saved change gives a new one
+func articleETag(article Article) string {
+	article.Author = nil
+	article.WordCount, article.ReadingTime = 0, 0
+	data, _ := json.Marshal(article)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// representationETag - the ETag of the article as a GET serves it: its text in locale, encoded in f.
+// The article's own text in JSON has articleETag, the other representations append their locale
+// and format so that a cache never answers a request with another one, see withoutVariant for If-Match.
+func representationETag(article Article, locale string, f *format) string {
+	etag := articleETag(article)
+	var variant []string
+	if locale != article.Locale {
+		variant = append(variant, locale)
+	}
+	if f != formats[0] {
+		variant = append(variant, f.mediaTypes[0][strings.Index(f.mediaTypes[0], "/")+1:])
+	}
+	if len(variant) == 0 {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "." + strings.Join(variant, ".") + `"`
+}
+
+// withoutVariant turns the ETags of other representations in an If-Match header back into
+// the ETag of the stored article: a write may be based on the article read in any locale or format
+func withoutVariant(header string) string {
+	candidates := strings.Split(header, ",")
+	for i, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if dot := strings.IndexByte(candidate, '.'); dot > 0 && strings.HasSuffix(candidate, `"`) {
+			candidate = candidate[:dot] + `"`
+		}
+		candidates[i] = candidate
+	}
+	return strings.Join(candidates, ",")
+}
+
+// etagMatches checks an If-Match / If-None-Match header value against etag.
+// weak decides whether W/ tags count (If-None-Match) or never match (If-Match, RFC 9110 strong comparison).
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch is run against the current article before every write to it
+func (s *server) checkIfMatch(r *http.Request, current Article) error {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if s.requirePreconditions {
+			return ErrPreconditionRequired
+		}
+		return nil
+	}
+	if !etagMatches(withoutVariant(header), articleETag(current), false) {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// notModified answers a GET with 304 when If-None-Match still matches the article,
+// served with its text in locale and in the negotiated format
+func notModified(w http.ResponseWriter, r *http.Request, article Article, locale string) bool {
+	etag := representationETag(article, locale, formatOf(r))
+	w.Header().Set("ETag", etag)
+	if header := r.Header.Get("If-None-Match"); header != "" && etagMatches(header, etag, true) {
+		w.Header().Add("Vary", "Accept") // render adds it to the full answer
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,298 @@
+// feeds.go
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// how many of the newest articles go into the Atom and RSS feeds
+const feedSize = 50
+
+// Atom 1.0 (RFC 4287)
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	Id        string      `xml:"id"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Links     []atomLink  `xml:"link"`
+	Author    *atomPerson `xml:"author,omitempty"`
+	Summary   string      `xml:"summary,omitempty"`
+}
+
+// RSS 2.0
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGuid struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description,omitempty"`
+	Author      string  `xml:"author,omitempty"` // "email (name)" as RSS wants it
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+// sitemaps.org protocol
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// baseURL - scheme and host the links in the feeds point to
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// feedArticles returns the published articles newest first by creation time, with their authors filled in
+func (s *server) feedArticles() ([]Article, error) {
+	all, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+	var articles []Article
+	for _, a := range all {
+		if isPublished(a) {
+			articles = append(articles, s.present(a))
+		}
+	}
+	sort.SliceStable(articles, func(i, j int) bool {
+		return articles[i].CreatedAt.After(articles[j].CreatedAt)
+	})
+	return articles, nil
+}
+
+// lastModified - the newest change over all articles
+func lastModified(articles []Article) time.Time {
+	var last time.Time
+	for _, a := range articles {
+		if a.UpdatedAt.After(last) {
+			last = a.UpdatedAt
+		}
+	}
+	return last
+}
+
+// storeClock - when any article or author last changed. Unlike the newest UpdatedAt of the published articles
+// it also moves on when an article is deleted or unpublished or its author renamed, so If-Modified-Since never sees it go back.
+// It is a storeListener, the articles fed to it at startup set it to the start of the server.
+type storeClock struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (c *storeClock) touch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = time.Now().UTC()
+}
+
+func (c *storeClock) articleSaved(article Article) { c.touch() }
+func (c *storeClock) articleDeleted(id string)     { c.touch() }
+
+// lastModified - the Last-Modified of the feeds, never before the newest article in them
+func (c *storeClock) lastModified(articles []Article) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last := lastModified(articles); last.After(c.last) {
+		return last
+	}
+	return c.last
+}
+
+// feedSite - where the links of the feeds point: the API, or the pages written by export-site
+type feedSite struct {
+	base     string // scheme and host, entry ids are base + "/article/" + id either way
+	self     string // directory of the feed files
+	home     string // the list of all articles
+	homeType string
+	page     func(a Article) string // the HTML page of an article
+}
+
+// apiSite - the feeds served by the API link to its own endpoints
+func apiSite(r *http.Request) feedSite {
+	base := baseURL(r)
+	return feedSite{
+		base:     base,
+		self:     base,
+		home:     base + "/articles",
+		homeType: "application/json",
+		page:     func(a Article) string { return base + "/article/" + a.Id + "/html" },
+	}
+}
+
+func (site feedSite) atom(articles []Article) atomFeed {
+	feed := atomFeed{
+		Title:   "Articles",
+		Id:      site.self + "/feed.atom",
+		Updated: lastModified(articles).UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: site.self + "/feed.atom", Rel: "self", Type: "application/atom+xml"},
+			{Href: site.home, Rel: "alternate", Type: site.homeType},
+		},
+	}
+	if len(articles) > feedSize {
+		articles = articles[:feedSize]
+	}
+	for _, a := range articles {
+		entry := atomEntry{
+			Title:     a.Title,
+			Id:        site.base + "/article/" + a.Id,
+			Published: a.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   a.UpdatedAt.UTC().Format(time.RFC3339),
+			Links:     []atomLink{{Href: site.page(a), Rel: "alternate", Type: "text/html"}},
+			Summary:   a.Desc,
+		}
+		if a.Author != nil {
+			entry.Author = &atomPerson{Name: a.Author.Name, Email: a.Author.Email}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
+
+func (site feedSite) rss(articles []Article) rssFeed {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         "Articles",
+			Link:          site.home,
+			Description:   "Newest articles",
+			LastBuildDate: lastModified(articles).UTC().Format(time.RFC1123Z),
+		},
+	}
+	if len(articles) > feedSize {
+		articles = articles[:feedSize]
+	}
+	for _, a := range articles {
+		item := rssItem{
+			Title:       a.Title,
+			Link:        site.page(a),
+			Description: a.Desc,
+			Guid:        rssGuid{Value: site.base + "/article/" + a.Id, IsPermaLink: false},
+			PubDate:     a.CreatedAt.UTC().Format(time.RFC1123Z),
+		}
+		if a.Author != nil {
+			item.Author = fmt.Sprintf("%s (%s)", a.Author.Email, a.Author.Name)
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	return feed
+}
+
+func (site feedSite) sitemap(articles []Article) sitemapURLSet {
+	urls := sitemapURLSet{}
+	for _, a := range articles {
+		urls.URLs = append(urls.URLs, sitemapURL{
+			Loc:     site.page(a),
+			LastMod: a.UpdatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	return urls
+}
+
+// encodeXML - a feed as a document, with the XML declaration
+func encodeXML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// serveXML writes a feed with a strong ETag and Last-Modified,
+// http.ServeContent answers If-None-Match and If-Modified-Since with 304
+func serveXML(w http.ResponseWriter, r *http.Request, contentType string, lastMod time.Time, v interface{}) {
+	data, err := encodeXML(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", lastMod, bytes.NewReader(data))
+}
+
+func (s *server) atomFeed(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: atomFeed")
+	articles, err := s.feedArticles()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveXML(w, r, "application/atom+xml; charset=utf-8", s.changed.lastModified(articles), apiSite(r).atom(articles))
+}
+
+func (s *server) rssFeed(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: rssFeed")
+	articles, err := s.feedArticles()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveXML(w, r, "application/rss+xml; charset=utf-8", s.changed.lastModified(articles), apiSite(r).rss(articles))
+}
+
+func (s *server) sitemap(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: sitemap")
+	articles, err := s.feedArticles()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveXML(w, r, "application/xml; charset=utf-8", s.changed.lastModified(articles), apiSite(r).sitemap(articles))
+}
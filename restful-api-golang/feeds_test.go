@@ -0,0 +1,57 @@
+// feeds_test.go
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFeedLastModifiedAfterDelete - removing the newest article must not turn Last-Modified back,
+// a client asking with If-Modified-Since only would keep its stale copy
+func TestFeedLastModifiedAfterDelete(t *testing.T) {
+	s := newTestServer(t, newMemoryStore(seedArticles()...))
+	h := newRouter(s)
+
+	var newest Article
+	decode(t, do(h, "POST", "/article", `{"title": "Newest"}`), &newest)
+	w := do(h, "GET", "/feed.atom", "")
+	before := w.Header().Get("Last-Modified")
+
+	time.Sleep(1100 * time.Millisecond) // Last-Modified counts whole seconds
+	if w := do(h, "DELETE", "/article/"+newest.Id, ""); w.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+	w = do(h, "GET", "/feed.atom", "", "If-Modified-Since", before)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d for a feed that lost its newest article since %s, want 200", w.Code, before)
+	}
+	after, _ := http.ParseTime(w.Header().Get("Last-Modified"))
+	if last, _ := http.ParseTime(before); !after.After(last) {
+		t.Errorf("Last-Modified went from %s to %s", before, w.Header().Get("Last-Modified"))
+	}
+
+	w = do(h, "GET", "/feed.atom", "", "If-Modified-Since", w.Header().Get("Last-Modified"))
+	if w.Code != http.StatusNotModified {
+		t.Errorf("got %d for an unchanged feed, want 304", w.Code)
+	}
+}
+
+// TestFeedLastModifiedAfterAuthorRename - the feeds name the authors, renaming one changes them
+func TestFeedLastModifiedAfterAuthorRename(t *testing.T) {
+	h := newRouter(newTestServer(t, newMemoryStore(seedArticles()...)))
+
+	before := do(h, "GET", "/feed.atom", "").Header().Get("Last-Modified")
+	time.Sleep(1100 * time.Millisecond) // Last-Modified counts whole seconds
+	if w := do(h, "PUT", "/authors/nolan@gmail.com", `{"Name": "Christopher Nolan"}`); w.Code != http.StatusOK {
+		t.Fatalf("rename: %d %s", w.Code, w.Body)
+	}
+	w := do(h, "GET", "/feed.atom", "", "If-Modified-Since", before)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d for a feed whose author was renamed since %s, want 200", w.Code, before)
+	}
+	if !strings.Contains(w.Body.String(), "Christopher Nolan") {
+		t.Errorf("the feed does not show the new name: %s", w.Body)
+	}
+}
@@ -0,0 +1,364 @@
+// filestore.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	journalFile  = "journal.ndjson"
+	snapshotFile = "snapshot.json"
+)
+
+// journalEntry - one line of the append-only journal.
+// Seq keeps growing across compactions so replay can skip entries the snapshot already contains.
+type journalEntry struct {
+	Seq     uint64   `json:"seq"`
+	Op      string   `json:"op"` // create, update or delete
+	Id      string   `json:"id"`
+	Article *Article `json:"article,omitempty"`
+}
+
+// snapshot - the compacted state of the store written by compact()
+type snapshot struct {
+	Seq      uint64    `json:"seq"`
+	Articles []Article `json:"articles"`
+}
+
+// fileStore keeps the articles in memory and makes every write durable:
+// each mutation is appended (and fsynced) to a journal before it is applied,
+// and compact() periodically folds the journal into a JSON snapshot.
+type fileStore struct {
+	mu       sync.Mutex // serializes writes, reads go straight to mem
+	mem      *memoryStore
+	dir      string
+	journal  *os.File
+	size     int64 // length of the journal up to the end of the last complete entry
+	broken   error // set when a failed append could not be cut off again, no more writes are taken
+	seq      uint64
+	pending  int  // journal entries written since the last snapshot
+	readOnly bool // loaded by readFileStore, the files are never changed
+}
+
+// openFileStore loads the snapshot in dir, replays the journal on top of it
+// and returns a store ready to append new entries.
+// If dir holds no data yet, the store starts with the seed articles.
+func openFileStore(dir string, seed ...Article) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &fileStore{mem: newMemoryStore(), dir: dir}
+	if err := s.load(seed); err != nil {
+		return nil, err
+	}
+
+	var err error
+	s.journal, err = os.OpenFile(filepath.Join(dir, journalFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// start every run from a clean snapshot with an empty journal
+	if err := s.compact(); err != nil {
+		s.journal.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// readFileStore loads the articles in dir like openFileStore but leaves dir as it is:
+// no snapshot is written and a torn or corrupt last journal entry is skipped, not cut off.
+// export-site reads the data of a server that may be running with it.
+func readFileStore(dir string, seed ...Article) (*memoryStore, error) {
+	s := &fileStore{mem: newMemoryStore(), dir: dir, readOnly: true}
+	if err := s.load(seed); err != nil {
+		return nil, err
+	}
+	return s.mem, nil
+}
+
+// load reads the snapshot and replays the journal, a dir without data gives the seed articles
+func (s *fileStore) load(seed []Article) error {
+	fresh, err := s.loadSnapshot()
+	if err != nil {
+		return err
+	}
+	replayed, err := s.replayJournal()
+	if err != nil {
+		return err
+	}
+	if fresh && replayed == 0 {
+		s.mem = newMemoryStore(seed...)
+	}
+	return nil
+}
+
+// loadSnapshot reports true when there is no snapshot yet
+func (s *fileStore) loadSnapshot() (bool, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return false, fmt.Errorf("snapshot %s: %v", snapshotFile, err)
+	}
+	s.mem = newMemoryStore(snap.Articles...)
+	s.seq = snap.Seq
+	return false, nil
+}
+
+// replayJournal applies the journal entries newer than the snapshot.
+// A torn last line (crash in the middle of a write) is cut off so the next append starts clean,
+// a corrupt line with entries after it fails the open: those entries were acknowledged and must not be dropped.
+func (s *fileStore) replayJournal() (int, error) {
+	path := filepath.Join(s.dir, journalFile)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var (
+		reader   = bufio.NewReader(f)
+		good     int64 // offset right after the last complete entry
+		replayed int
+	)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("filestore: dropping torn journal entry at offset %d", good)
+				return replayed, s.truncateJournal(path, good)
+			}
+			return replayed, nil
+		}
+		if err != nil {
+			return replayed, err
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			if _, peekErr := reader.Peek(1); peekErr != io.EOF {
+				return replayed, fmt.Errorf("journal %s: corrupt entry at offset %d with more entries after it: %v", path, good, err)
+			}
+			log.Printf("filestore: dropping corrupt last journal entry at offset %d: %v", good, err)
+			return replayed, s.truncateJournal(path, good)
+		}
+		good += int64(len(line))
+		if entry.Seq <= s.seq {
+			continue // already part of the snapshot
+		}
+		if err := s.apply(entry); err != nil {
+			return replayed, fmt.Errorf("journal entry %d: %v", entry.Seq, err)
+		}
+		s.seq = entry.Seq
+		replayed++
+	}
+}
+
+// truncateJournal cuts the journal off at offset, a read-only store only stops reading there
+func (s *fileStore) truncateJournal(path string, offset int64) error {
+	if s.readOnly {
+		return nil
+	}
+	return os.Truncate(path, offset)
+}
+
+func (s *fileStore) apply(entry journalEntry) error {
+	switch entry.Op {
+	case "create":
+		_, err := s.mem.Create(*entry.Article)
+		return err
+	case "update":
+		_, err := s.mem.Update(entry.Id, *entry.Article)
+		return err
+	case "delete":
+		return s.mem.Delete(entry.Id)
+	}
+	return fmt.Errorf("unknown op %q", entry.Op)
+}
+
+// write appends the entry to the journal and applies it, must be called with s.mu held
+func (s *fileStore) write(entry journalEntry) error {
+	if s.broken != nil {
+		return s.broken
+	}
+	entry.Seq = s.seq + 1
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := s.journal.Write(line); err != nil {
+		return s.undoWrite(err)
+	}
+	if err := s.journal.Sync(); err != nil {
+		return s.undoWrite(err)
+	}
+	s.size += int64(len(line))
+	s.seq = entry.Seq
+	s.pending++
+	return s.apply(entry)
+}
+
+// undoWrite cuts what a failed write left of its entry off the journal again.
+// Otherwise the next entry would continue the same line and replay would stop there.
+func (s *fileStore) undoWrite(err error) error {
+	if terr := s.journal.Truncate(s.size); terr != nil {
+		s.broken = fmt.Errorf("journal damaged by a failed write (%v), restart to recover: %v", err, terr)
+		log.Printf("filestore: %v", s.broken)
+		return s.broken
+	}
+	return err
+}
+
+func (s *fileStore) Get(id string) (Article, error) {
+	return s.mem.Get(id)
+}
+
+func (s *fileStore) List() ([]Article, error) {
+	return s.mem.List()
+}
+
+func (s *fileStore) Create(article Article) (Article, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.mem.Get(article.Id); err == nil {
+		return Article{}, ErrArticleExists
+	}
+	if err := s.write(journalEntry{Op: "create", Id: article.Id, Article: &article}); err != nil {
+		return Article{}, err
+	}
+	return article.clone(), nil
+}
+
+func (s *fileStore) Update(id string, article Article) (Article, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.mem.Get(id); err != nil {
+		return Article{}, err
+	}
+	article.Id = id
+	if err := s.write(journalEntry{Op: "update", Id: id, Article: &article}); err != nil {
+		return Article{}, err
+	}
+	return article.clone(), nil
+}
+
+func (s *fileStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.mem.Get(id); err != nil {
+		return err
+	}
+	return s.write(journalEntry{Op: "delete", Id: id})
+}
+
+// compact writes the current state to a new snapshot and empties the journal.
+func (s *fileStore) compact() error {
+	articles, err := s.mem.List()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(snapshot{Seq: s.seq, Articles: articles}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filepath.Join(s.dir, snapshotFile), data); err != nil {
+		return err
+	}
+
+	// a crash before the truncate is harmless, replay skips entries with seq <= snapshot seq
+	if err := s.journal.Truncate(0); err != nil {
+		return err
+	}
+	s.size, s.broken, s.pending = 0, nil, 0
+	return s.journal.Sync()
+}
+
+// compactEvery runs compact on every tick that saw new journal entries
+func (s *fileStore) compactEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.mu.Lock()
+		if s.pending > 0 {
+			if err := s.compact(); err != nil {
+				log.Printf("filestore: compaction failed: %v", err)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// writeFileAtomic writes data to a temp file first and renames it over path,
+// so a crash leaves either the old or the new file, never half of one
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes a rename inside dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// loadJSONFile reads a JSON file written by saveJSONFile into v, a missing file leaves v untouched
+func loadJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSONFile is used by the small side stores (authors, ...) that rewrite their whole state on every write
+func saveJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
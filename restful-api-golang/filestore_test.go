@@ -0,0 +1,138 @@
+// filestore_test.go
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestFileStore(t *testing.T, dir string) *fileStore {
+	t.Helper()
+	s, err := openFileStore(dir, seedArticles()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.journal.Close() })
+	return s
+}
+
+// appendJournal writes raw bytes at the end of the journal in dir, like a crash or a bad disk would
+func appendJournal(t *testing.T, dir, data string) {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestJournal makes a store in a new directory and leaves three entries in its journal
+func writeTestJournal(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir)
+	if _, err := s.Create(Article{Id: "3", Title: "Three"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Update("1", Article{Title: "One again"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("2"); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func articleIds(t *testing.T, s ArticleStore) string {
+	t.Helper()
+	articles, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, a := range articles {
+		ids = append(ids, a.Id)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestJournalReplay(t *testing.T) {
+	dir := writeTestJournal(t)
+
+	s := openTestFileStore(t, dir)
+	if ids := articleIds(t, s); ids != "1,3" {
+		t.Errorf("got articles %s after replay, want 1,3", ids)
+	}
+	if a, _ := s.Get("1"); a.Title != "One again" {
+		t.Errorf("article 1 has title %q after replay, want the updated one", a.Title)
+	}
+
+	// the open compacted the journal into the snapshot, a second open must give the same state
+	s.journal.Close()
+	if ids := articleIds(t, openTestFileStore(t, dir)); ids != "1,3" {
+		t.Errorf("got articles %s after reopening the snapshot, want 1,3", ids)
+	}
+}
+
+func TestJournalTornTail(t *testing.T) {
+	dir := writeTestJournal(t)
+	appendJournal(t, dir, `{"seq":4,"op":"create","id":"4","art`)
+
+	s := openTestFileStore(t, dir)
+	if ids := articleIds(t, s); ids != "1,3" {
+		t.Errorf("got articles %s, want the torn entry dropped and the rest kept", ids)
+	}
+}
+
+func TestJournalCorruptLastLine(t *testing.T) {
+	dir := writeTestJournal(t)
+	appendJournal(t, dir, "garbage\n")
+
+	if ids := articleIds(t, openTestFileStore(t, dir)); ids != "1,3" {
+		t.Errorf("got articles %s, want the corrupt last entry dropped and the rest kept", ids)
+	}
+}
+
+func TestJournalCorruptMiddleFails(t *testing.T) {
+	dir := writeTestJournal(t)
+	appendJournal(t, dir, "garbage\n"+`{"seq":4,"op":"delete","id":"3"}`+"\n")
+
+	if _, err := openFileStore(dir, seedArticles()...); err == nil {
+		t.Fatal("opened a journal with a corrupt entry in the middle, want an error")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, journalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"seq":4`) {
+		t.Error("the failed open truncated the entries after the corrupt one")
+	}
+}
+
+// TestJournalFailedWriteIsCutOff - what a failed append left behind must not swallow the next entry
+func TestJournalFailedWriteIsCutOff(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir)
+	if _, err := s.Create(Article{Id: "3", Title: "Three"}); err != nil {
+		t.Fatal(err)
+	}
+
+	appendJournal(t, dir, `{"seq":5,"op":"crea`) // half an entry, as a write failing midway leaves it
+	if err := s.undoWrite(errors.New("disk full")); err == nil {
+		t.Fatal("undoWrite swallowed the write error")
+	}
+	if _, err := s.Create(Article{Id: "4", Title: "Four"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.journal.Close()
+	if ids := articleIds(t, openTestFileStore(t, dir)); ids != "1,2,3,4" {
+		t.Errorf("got articles %s after a failed and a good write, want 1,2,3,4", ids)
+	}
+}
@@ -0,0 +1,291 @@
+// gitstore.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	gitobject "github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// the directory of the repository the article files live in
+const gitArticlesDir = "articles"
+
+// who commits the writes of articles without an author
+var gitFallbackAuthor = Author{Name: "restful-api-golang", Email: "articles@localhost"}
+
+// gitStore keeps every article as a JSON file in a git repository and commits each write,
+// authored by the article's author. The repository is a normal one: git log, git blame
+// and git clone work on it, the articles are read into memory from HEAD when it is opened.
+type gitStore struct {
+	mu       sync.Mutex // serializes writes, reads go straight to mem
+	mem      *memoryStore
+	dir      string
+	repo     *git.Repository
+	worktree *git.Worktree
+	authorOf func(email string) (Author, error) // looks up the Author behind an AuthorId, set once the author store exists
+}
+
+// openGitStore opens the repository in dir, creating it when there is none yet.
+// A repository without commits starts with the seed articles, one commit each.
+func openGitStore(dir string, seed ...Article) (*gitStore, error) {
+	repo, err := git.PlainOpen(dir)
+	if err == git.ErrRepositoryNotExists {
+		repo, err = git.PlainInit(dir, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	s := &gitStore{mem: newMemoryStore(), dir: dir, repo: repo, worktree: worktree}
+
+	fresh, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if fresh {
+		for _, article := range seed {
+			if _, err := s.Create(article); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// readGitStore loads the articles of the repository in dir like openGitStore,
+// without creating the repository or committing the seed articles when there is none yet
+func readGitStore(dir string, seed ...Article) (*memoryStore, error) {
+	repo, err := git.PlainOpen(dir)
+	if err == git.ErrRepositoryNotExists {
+		return newMemoryStore(seed...), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &gitStore{mem: newMemoryStore(), dir: dir, repo: repo}
+	fresh, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if fresh {
+		return newMemoryStore(seed...), nil
+	}
+	return s.mem, nil
+}
+
+// load reads the articles of the HEAD commit, it reports true when the repository has no commits yet
+func (s *gitStore) load() (bool, error) {
+	head, err := s.repo.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	commit, err := s.repo.CommitObject(head.Hash())
+	if err != nil {
+		return false, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return false, err
+	}
+
+	var articles []Article
+	err = tree.Files().ForEach(func(f *gitobject.File) error {
+		if !strings.HasPrefix(f.Name, gitArticlesDir+"/") || !strings.HasSuffix(f.Name, ".json") {
+			return nil
+		}
+		reader, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		var article Article
+		if err := json.Unmarshal(data, &article); err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		articles = append(articles, article)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// the files come in name order, the oldest article goes first like in the other stores
+	sort.SliceStable(articles, func(i, j int) bool {
+		if !articles[i].CreatedAt.Equal(articles[j].CreatedAt) {
+			return articles[i].CreatedAt.Before(articles[j].CreatedAt)
+		}
+		return articles[i].Id < articles[j].Id
+	})
+	s.mem = newMemoryStore(articles...)
+	return false, nil
+}
+
+// articlePath - the file of an article, relative to the repository
+func articlePath(id string) string {
+	return gitArticlesDir + "/" + url.PathEscape(id) + ".json"
+}
+
+// signature - the commit author for a write of article: its Author, or the fallback for articles without one
+func (s *gitStore) signature(article Article) *gitobject.Signature {
+	author := gitFallbackAuthor
+	switch {
+	case article.Author != nil:
+		author = *article.Author
+	case article.AuthorId != "" && s.authorOf != nil:
+		if found, err := s.authorOf(article.AuthorId); err == nil {
+			author = found
+		} else {
+			author = Author{Email: article.AuthorId}
+		}
+	case article.AuthorId != "":
+		author = Author{Email: article.AuthorId}
+	}
+	if author.Name == "" {
+		author.Name = author.Email
+		if addr, err := mail.ParseAddress(author.Email); err == nil {
+			author.Name = strings.SplitN(addr.Address, "@", 2)[0]
+		}
+	}
+	return &gitobject.Signature{Name: author.Name, Email: author.Email, When: time.Now()}
+}
+
+// commitMessage - "Create article 1: Hello", followed by who made the edit when that is known
+func commitMessage(op string, article Article) string {
+	msg := fmt.Sprintf("%s article %s: %s\n", op, article.Id, article.Title)
+	if article.UpdatedBy != "" {
+		msg += "\nEdited-by: " + article.UpdatedBy + "\n"
+	}
+	return msg
+}
+
+// commitFile writes the article file and commits it, must be called with s.mu held.
+// Writing the same content again is not a change, no commit is made then.
+func (s *gitStore) commitFile(op string, article Article) error {
+	stored := article.clone()
+	stored.Author = nil // the author store holds the author, the file only references it
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	path := articlePath(article.Id)
+	full := filepath.Join(s.dir, filepath.FromSlash(path))
+	if old, err := os.ReadFile(full); err == nil && bytes.Equal(old, data) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(full, data); err != nil {
+		return err
+	}
+	if _, err := s.worktree.Add(path); err != nil {
+		return s.undo(err)
+	}
+	return s.commit(commitMessage(op, article), article)
+}
+
+// commit records the staged change, must be called with s.mu held
+func (s *gitStore) commit(msg string, article Article) error {
+	sig := s.signature(article)
+	_, err := s.worktree.Commit(msg, &git.CommitOptions{
+		Author:            sig,
+		Committer:         sig,
+		AllowEmptyCommits: true, // deleting the last article leaves an empty tree
+	})
+	if err != nil {
+		return s.undo(err)
+	}
+	return nil
+}
+
+// undo puts the worktree and the index back to HEAD after a write that could not be committed
+func (s *gitStore) undo(cause error) error {
+	head, err := s.repo.Head()
+	if err != nil {
+		return cause // nothing committed yet, nothing to go back to
+	}
+	if err := s.worktree.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.HardReset}); err != nil {
+		return fmt.Errorf("%v (and the worktree could not be reset: %v)", cause, err)
+	}
+	return cause
+}
+
+func (s *gitStore) Get(id string) (Article, error) {
+	return s.mem.Get(id)
+}
+
+func (s *gitStore) List() ([]Article, error) {
+	return s.mem.List()
+}
+
+func (s *gitStore) Create(article Article) (Article, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.mem.Get(article.Id); err == nil {
+		return Article{}, ErrArticleExists
+	}
+	if err := s.commitFile("Create", article); err != nil {
+		return Article{}, err
+	}
+	return s.mem.Create(article)
+}
+
+func (s *gitStore) Update(id string, article Article) (Article, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.mem.Get(id); err != nil {
+		return Article{}, err
+	}
+	article.Id = id
+	if err := s.commitFile("Update", article); err != nil {
+		return Article{}, err
+	}
+	return s.mem.Update(id, article)
+}
+
+// Delete removes the file in a commit authored by the author of the deleted article
+func (s *gitStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	article, err := s.mem.Get(id)
+	if err != nil {
+		return err
+	}
+	if _, err := s.worktree.Remove(articlePath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return s.undo(err)
+	}
+	// the last editor of the article is not the one deleting it, so no Edited-by here
+	if err := s.commit(fmt.Sprintf("Delete article %s: %s\n", id, article.Title), article); err != nil {
+		return err
+	}
+	return s.mem.Delete(id)
+}
@@ -0,0 +1,151 @@
+// gitstore_test.go
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitobject "github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func openTestGitStore(t *testing.T, dir string) *gitStore {
+	t.Helper()
+	s, err := openGitStore(dir, seedArticles()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// gitLog returns the commits of the repository, newest first
+func gitLog(t *testing.T, s *gitStore) []*gitobject.Commit {
+	t.Helper()
+	head, err := s.repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	iter, err := s.repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var commits []*gitobject.Commit
+	if err := iter.ForEach(func(c *gitobject.Commit) error {
+		commits = append(commits, c)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return commits
+}
+
+// TestGitStoreCommitsEachWrite - a create, an update and a delete make one commit each, authored by the article's author
+func TestGitStoreCommitsEachWrite(t *testing.T) {
+	s := openTestGitStore(t, t.TempDir())
+	s.authorOf = func(email string) (Author, error) {
+		return Author{Name: "Bea Author", Email: email}, nil
+	}
+	if n := len(gitLog(t, s)); n != 2 {
+		t.Fatalf("a new repository has %d commits, want one per seed article", n)
+	}
+
+	if _, err := s.Create(Article{Id: "3", Title: "Three", Author: &Author{Name: "Ann", Email: "ann@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Update("3", Article{Title: "Three again", AuthorId: "bea@example.com", UpdatedBy: "ann@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	commits := gitLog(t, s)
+	if len(commits) != 5 {
+		t.Fatalf("got %d commits, want 2 seed commits and one per write", len(commits))
+	}
+	for i, want := range []struct{ msg, name, email string }{
+		{"Delete article 1: Hello\n", "Chris Nolan", "nolan@gmail.com"},
+		{"Update article 3: Three again\n\nEdited-by: ann@example.com\n", "Bea Author", "bea@example.com"},
+		{"Create article 3: Three\n", "Ann", "ann@example.com"},
+	} {
+		c := commits[i]
+		if c.Message != want.msg {
+			t.Errorf("commit %d: message %q, want %q", i, c.Message, want.msg)
+		}
+		if c.Author.Name != want.name || c.Author.Email != want.email {
+			t.Errorf("commit %d: author %s <%s>, want %s <%s>", i, c.Author.Name, c.Author.Email, want.name, want.email)
+		}
+	}
+
+	// writing the same article again changes no file, so there is nothing to commit
+	stored, _ := s.Get("3")
+	if _, err := s.Update("3", stored); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(gitLog(t, s)); n != 5 {
+		t.Errorf("an unchanged update made a commit, got %d commits, want 5", n)
+	}
+}
+
+// TestGitStoreReopen - the articles come back from HEAD and the seed is not committed again
+func TestGitStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestGitStore(t, dir)
+	later := time.Now().UTC().Add(time.Minute) // HEAD is read back oldest first
+	if _, err := s.Create(Article{Id: "3", Title: "Three", CreatedAt: later}); err != nil {
+		t.Fatal(err)
+	}
+	two, _ := s.Get("2")
+	two.Title = "Two again"
+	if _, err := s.Update("2", two); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openTestGitStore(t, dir)
+	if got := articleIds(t, reopened); got != "2,3" {
+		t.Errorf("reopened store has articles %s, want 2,3", got)
+	}
+	if article, _ := reopened.Get("2"); article.Title != "Two again" {
+		t.Errorf("article 2 after reopening: title %q, want the updated one", article.Title)
+	}
+	if n := len(gitLog(t, reopened)); n != 5 {
+		t.Errorf("got %d commits after reopening, want 5", n)
+	}
+
+	// the reopened store keeps committing on top of the history
+	if _, err := reopened.Create(Article{Id: "4", Title: "Four"}); err != nil {
+		t.Fatal(err)
+	}
+	if commits := gitLog(t, reopened); len(commits) != 6 || commits[0].Message != "Create article 4: Four\n" {
+		t.Errorf("got %d commits after a write to the reopened store, want 6 with the create on top", len(commits))
+	}
+}
+
+// TestReadGitStore - reading an existing repository gives its articles and commits nothing
+func TestReadGitStore(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestGitStore(t, dir)
+	if _, err := s.Create(Article{Id: "3", Title: "Three", CreatedAt: time.Now().UTC().Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := readGitStore(dir, seedArticles()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := articleIds(t, read); got != "2,3" {
+		t.Errorf("readGitStore gives articles %s, want 2,3 from HEAD and not the seed", got)
+	}
+	if _, err := read.Create(Article{Id: "4", Title: "Four"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(gitLog(t, s)); n != 4 {
+		t.Errorf("got %d commits, a write to the read store must not commit", n)
+	}
+}
@@ -0,0 +1,148 @@
+// ids.go
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
+)
+
+// IDGenerator hands out new article ids. Both real implementations are time-sortable,
+// tests can plug in sequenceGenerator to get predictable ids.
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// ulidGenerator - ULIDs with monotonic entropy, so ids made in the same millisecond still sort in order
+type ulidGenerator struct {
+	mu      sync.Mutex // ulid.MonotonicEntropy is not safe for concurrent use
+	entropy *ulid.MonotonicEntropy
+}
+
+func newULIDGenerator() *ulidGenerator {
+	return &ulidGenerator{entropy: ulid.Monotonic(rand.Reader, 0)}
+}
+
+func (g *ulidGenerator) NewID() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	id, err := ulid.New(ulid.Timestamp(time.Now()), g.entropy)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// uuidV7Generator - RFC 9562 version 7 UUIDs
+type uuidV7Generator struct{}
+
+func (uuidV7Generator) NewID() (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// sequenceGenerator - 1, 2, 3, ... for tests and demos, seedIDs moves it past the ids in use
+type sequenceGenerator struct {
+	mu   sync.Mutex
+	next int
+}
+
+func (g *sequenceGenerator) NewID() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.next++
+	return strconv.Itoa(g.next), nil
+}
+
+// startAfter makes the next id come after id when id is a number
+func (g *sequenceGenerator) startAfter(id string) {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if n > g.next {
+		g.next = n
+	}
+}
+
+// seedIDs moves a sequence generator past the ids of the stored and the trashed articles and
+// their comments, which take their ids from the same sequence.
+// Starting at 1 on every launch, it would only hand out taken ids once a store holds a few.
+func (s *server) seedIDs() error {
+	seq, ok := s.ids.(*sequenceGenerator)
+	if !ok {
+		return nil
+	}
+	articles, err := s.store.List()
+	if err != nil {
+		return err
+	}
+	for _, article := range articles {
+		seq.startAfter(article.Id)
+	}
+	for _, entry := range s.trash.List() {
+		seq.startAfter(entry.Article.Id)
+		for _, comment := range entry.Comments {
+			seq.startAfter(comment.Id)
+		}
+	}
+	for _, comment := range s.comments.List() {
+		seq.startAfter(comment.Id)
+	}
+	return nil
+}
+
+func newIDGenerator(kind string) (IDGenerator, error) {
+	switch kind {
+	case "ulid":
+		return newULIDGenerator(), nil
+	case "uuidv7":
+		return uuidV7Generator{}, nil
+	case "sequence":
+		return &sequenceGenerator{}, nil
+	}
+	return nil, fmt.Errorf("unknown id generator %q, use ulid, uuidv7 or sequence", kind)
+}
+
+// how often createWithNewID draws a new id before giving up
+const maxIDAttempts = 10
+
+var ErrNoFreeID = errors.New("could not generate an unused article id")
+
+// createWithNewID gives the article a fresh id and stores it.
+// Ids already taken in the store are skipped, which also covers two requests racing for the same id.
+func (s *server) createWithNewID(article Article) (Article, error) {
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		id, err := s.ids.NewID()
+		if err != nil {
+			return Article{}, err
+		}
+		if _, err := s.store.Get(id); err == nil {
+			continue
+		}
+
+		article.Id = id
+		article.Slug = s.slugs.claim(id, article.Title)
+		created, err := s.store.Create(article)
+		if err != nil {
+			s.slugs.release(id)
+		}
+		if err == ErrArticleExists {
+			continue
+		}
+		return created, err
+	}
+	return Article{}, ErrNoFreeID
+}
@@ -0,0 +1,111 @@
+// ids_test.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+)
+
+func TestSequenceIDsAreDeterministic(t *testing.T) {
+	h := newRouter(newTestServer(t, newMemoryStore(seedArticles()...)))
+	for _, want := range []string{"3", "4", "5"} {
+		var created Article
+		decode(t, do(h, "POST", "/article", `{"title": "Next"}`), &created)
+		if created.Id != want {
+			t.Errorf("got id %q, want %q after the seeded 1 and 2", created.Id, want)
+		}
+	}
+}
+
+// TestSequenceIDsAfterRestart - a persistent store with more articles than maxIDAttempts
+// must not make every create fail once the server starts counting again
+func TestSequenceIDsAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	s, _, err := openServer("persistent", dir, "", "sequence", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newRouter(s)
+	for i := 0; i < maxIDAttempts+2; i++ {
+		if w := do(h, "POST", "/article", fmt.Sprintf(`{"title": "Article %d"}`, i)); w.Code != http.StatusOK {
+			t.Fatalf("create %d: %d %s", i, w.Code, w.Body)
+		}
+	}
+	// one in the trash counts too, restoring it must not clash with a new article
+	if w := do(h, "DELETE", "/article/14", ""); w.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+	s.store.ArticleStore.(*fileStore).journal.Close()
+
+	s, _, err = openServer("persistent", dir, "", "sequence", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.store.ArticleStore.(*fileStore).journal.Close() })
+	w := do(newRouter(s), "POST", "/article", `{"title": "After the restart"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create after the restart: %d %s", w.Code, w.Body)
+	}
+	var created Article
+	decode(t, w, &created)
+	if created.Id != "15" {
+		t.Errorf("got id %q after the restart, want 15", created.Id)
+	}
+}
+
+// TestSequenceIDsAfterRestartWithComments - comments share the sequence with the articles,
+// their ids must be skipped after a restart too
+func TestSequenceIDsAfterRestartWithComments(t *testing.T) {
+	dir := t.TempDir()
+	s, _, err := openServer("persistent", dir, "", "sequence", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newRouter(s)
+	comment := `{"body": "first", "author": {"Name": "Ann", "Email": "ann@example.com"}}`
+	for i := 0; i < maxIDAttempts+2; i++ {
+		if w := do(h, "POST", "/article/1/comments", comment); w.Code != http.StatusCreated {
+			t.Fatalf("comment %d: %d %s", i, w.Code, w.Body)
+		}
+	}
+	s.store.ArticleStore.(*fileStore).journal.Close()
+	s.revisions.log.Close()
+
+	s, _, err = openServer("persistent", dir, "", "sequence", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.store.ArticleStore.(*fileStore).journal.Close()
+		s.revisions.log.Close()
+	})
+	h = newRouter(s)
+	if w := do(h, "POST", "/article/1/comments", comment); w.Code != http.StatusCreated {
+		t.Fatalf("comment after the restart: %d %s", w.Code, w.Body)
+	}
+	var created Article
+	decode(t, do(h, "POST", "/article", `{"title": "After the restart"}`), &created)
+	if want := fmt.Sprint(2 + maxIDAttempts + 2 + 2); created.Id != want {
+		t.Errorf("got id %q after the restart, want %s", created.Id, want)
+	}
+}
+
+func TestTimeSortableIDs(t *testing.T) {
+	for _, kind := range []string{"ulid", "uuidv7"} {
+		g, err := newIDGenerator(kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, 1000)
+		for i := range ids {
+			if ids[i], err = g.NewID(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !sort.StringsAreSorted(ids) {
+			t.Errorf("%s ids made one after the other do not sort in order", kind)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+// list.go
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// the keys GET /articles can sort on, "-" in front of a key reverses it
+var sortKeys = map[string]func(a Article) string{
+	"id":    func(a Article) string { return a.Id },
+	"title": func(a Article) string { return strings.ToLower(a.Title) },
+	"desc":  func(a Article) string { return strings.ToLower(a.Desc) },
+	"author": func(a Article) string {
+		if a.Author == nil {
+			return ""
+		}
+		return strings.ToLower(a.Author.Name)
+	},
+	"email": func(a Article) string {
+		if a.Author == nil {
+			return ""
+		}
+		return strings.ToLower(a.Author.Email)
+	},
+}
+
+type sortField struct {
+	key  string
+	desc bool
+}
+
+// listQuery - the parsed ?limit, offset, cursor, sort and filter parameters of GET /articles
+type listQuery struct {
+	sort        []sortField
+	sortSpec    string
+	limit       int // 0 means everything
+	offset      int
+	cursor      *listCursor
+	authorName  string
+	authorEmail string
+	titlePrefix string
+	tags        []string // ?tag=, repeatable
+	anyTag      bool     // ?tag_mode=any matches articles with one of the tags instead of all of them
+	category    string
+	statuses    map[string]bool // ?status=, repeatable, "all" for every status; only published articles when empty
+}
+
+// listCursor - what an opaque cursor carries: the sort it belongs to and the sort key of the last article served
+type listCursor struct {
+	Sort  string   `json:"s"`
+	After []string `json:"a"`
+}
+
+func (c listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
+
+func parseListQuery(values url.Values) (listQuery, error) {
+	q := listQuery{
+		authorName:  values.Get("author_name"),
+		authorEmail: values.Get("author_email"),
+		titlePrefix: values.Get("title_prefix"),
+		tags:        normalizeTags(values["tag"]),
+		category:    strings.TrimSpace(values.Get("category")),
+	}
+	for _, status := range values["status"] {
+		if status == "all" {
+			status = ""
+		} else if _, ok := transitions[status]; !ok {
+			return q, ErrInvalidStatus
+		}
+		if q.statuses == nil {
+			q.statuses = map[string]bool{}
+		}
+		q.statuses[status] = true
+	}
+	if len(q.statuses) == 0 && values.Get("preview") == "true" {
+		q.statuses = map[string]bool{"": true}
+	}
+	switch values.Get("tag_mode") {
+	case "", "all":
+	case "any":
+		q.anyTag = true
+	default:
+		return q, errors.New("tag_mode must be all or any")
+	}
+
+	var err error
+	if q.limit, q.offset, err = parseLimitOffset(values); err != nil {
+		return q, err
+	}
+
+	q.sortSpec = values.Get("sort")
+	if v := values.Get("cursor"); v != "" {
+		if values.Get("offset") != "" {
+			return q, errors.New("use either cursor or offset, not both")
+		}
+		c, err := decodeCursor(v)
+		if err != nil {
+			return q, err
+		}
+		if q.sortSpec != "" && q.sortSpec != c.Sort {
+			return q, errors.New("sort does not match the cursor")
+		}
+		q.sortSpec = c.Sort
+		q.cursor = c
+		if q.limit == 0 {
+			q.limit = defaultPageSize
+		}
+	}
+
+	for _, key := range strings.Split(q.sortSpec, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		field := sortField{key: strings.TrimPrefix(key, "-"), desc: strings.HasPrefix(key, "-")}
+		if _, ok := sortKeys[field.key]; !ok {
+			return q, fmt.Errorf("cannot sort on %q", field.key)
+		}
+		q.sort = append(q.sort, field)
+	}
+	// id breaks the ties so the order (and every cursor) is stable
+	q.sort = append(q.sort, sortField{key: "id"})
+
+	if q.cursor != nil && len(q.cursor.After) != len(q.sort) {
+		return q, errors.New("invalid cursor")
+	}
+	return q, nil
+}
+
+// parseLimitOffset reads ?limit and ?offset, a limit of 0 means everything
+func parseLimitOffset(values url.Values) (limit, offset int, err error) {
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, errors.New("limit must be a positive number")
+		}
+		if n > maxPageSize {
+			n = maxPageSize
+		}
+		limit = n
+	}
+	if v := values.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("offset must be zero or a positive number")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+func (q listQuery) matches(a Article) bool {
+	switch {
+	case q.statuses == nil:
+		if !isPublished(a) {
+			return false
+		}
+	case !q.statuses[""] && !q.statuses[statusOf(a)]:
+		return false
+	}
+	if q.titlePrefix != "" && !strings.HasPrefix(strings.ToLower(a.Title), strings.ToLower(q.titlePrefix)) {
+		return false
+	}
+	if q.authorName != "" && (a.Author == nil || !strings.EqualFold(a.Author.Name, q.authorName)) {
+		return false
+	}
+	if q.authorEmail != "" && (a.Author == nil || !strings.EqualFold(a.Author.Email, q.authorEmail)) {
+		return false
+	}
+	if q.category != "" && !strings.EqualFold(a.Category, q.category) {
+		return false
+	}
+	if !hasTags(a, q.tags, q.anyTag) {
+		return false
+	}
+	return true
+}
+
+func (q listQuery) keyOf(a Article) []string {
+	key := make([]string, len(q.sort))
+	for i, field := range q.sort {
+		key[i] = sortKeys[field.key](a)
+	}
+	return key
+}
+
+// compare orders two sort keys, taking the direction of every field into account
+func (q listQuery) compare(a, b []string) int {
+	for i, field := range q.sort {
+		c := strings.Compare(a[i], b[i])
+		if field.key == "id" {
+			c = compareIds(a[i], b[i])
+		}
+		if field.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareIds orders numeric ids by their value, so "9" comes before "10".
+// Numeric ids go before the others, which are compared as strings, so the order stays total when both kinds are mixed.
+func compareIds(a, b string) int {
+	numA, numB := isNumeric(a), isNumeric(b)
+	switch {
+	case numA && !numB:
+		return -1
+	case !numA && numB:
+		return 1
+	case numA && numB:
+		trimmedA, trimmedB := strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(trimmedA) != len(trimmedB) {
+			if len(trimmedA) < len(trimmedB) {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(trimmedA, trimmedB); c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// isNumeric - s is a non-negative whole number, of any length
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// listPage - one page of articles plus what is needed to link to the neighbouring pages
+type listPage struct {
+	articles []Article
+	total    int    // matching articles over all pages
+	next     string // cursor for the next page, empty on the last page
+}
+
+// apply filters and sorts articles and cuts out the requested page
+func (q listQuery) apply(articles []Article) listPage {
+	filtered := make([]Article, 0, len(articles))
+	for _, a := range articles {
+		if q.matches(a) {
+			filtered = append(filtered, a)
+		}
+	}
+	keys := make(map[string][]string, len(filtered))
+	for _, a := range filtered {
+		keys[a.Id] = q.keyOf(a)
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return q.compare(keys[filtered[i].Id], keys[filtered[j].Id]) < 0
+	})
+
+	page := listPage{total: len(filtered)}
+	start := q.offset
+	if q.cursor != nil {
+		start = sort.Search(len(filtered), func(i int) bool {
+			return q.compare(keys[filtered[i].Id], q.cursor.After) > 0
+		})
+	}
+	if start > len(filtered) {
+		start = len(filtered)
+	}
+	end := len(filtered)
+	if q.limit > 0 && start+q.limit < end {
+		end = start + q.limit
+	}
+	page.articles = filtered[start:end]
+
+	if end < len(filtered) && end > start {
+		page.next = listCursor{Sort: q.sortSpec, After: keys[filtered[end-1].Id]}.encode()
+	}
+	return page
+}
+
+// writePageHeaders sets X-Total-Count and the RFC 8288 Link header for a page
+func writePageHeaders(w http.ResponseWriter, r *http.Request, q listQuery, page listPage) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.total))
+	if q.limit == 0 {
+		return
+	}
+
+	link := func(rel string, set map[string]string) string {
+		u := *r.URL
+		values := u.Query()
+		values.Del("offset")
+		values.Del("cursor")
+		if q.sortSpec != "" {
+			values.Set("sort", q.sortSpec) // a cursor carries its sort, the links spell it out
+		}
+		for k, v := range set {
+			values.Set(k, v)
+		}
+		u.RawQuery = values.Encode()
+		u.Scheme, u.Host = "http", r.Host
+		if r.TLS != nil {
+			u.Scheme = "https"
+		}
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel)
+	}
+
+	limit := strconv.Itoa(q.limit)
+	links := []string{link("first", map[string]string{"limit": limit})}
+	if q.cursor != nil {
+		if page.next != "" {
+			links = append(links, link("next", map[string]string{"limit": limit, "cursor": page.next}))
+		}
+	} else {
+		if q.offset > 0 {
+			prev := q.offset - q.limit
+			if prev < 0 {
+				prev = 0
+			}
+			links = append(links, link("prev", map[string]string{"limit": limit, "offset": strconv.Itoa(prev)}))
+		}
+		if q.offset+q.limit < page.total {
+			links = append(links, link("next", map[string]string{"limit": limit, "offset": strconv.Itoa(q.offset + q.limit)}))
+		}
+		last := 0
+		if page.total > 0 {
+			last = (page.total - 1) / q.limit * q.limit
+		}
+		links = append(links, link("last", map[string]string{"limit": limit, "offset": strconv.Itoa(last)}))
+	}
+	if page.next != "" {
+		// offset pages can also be continued with a cursor
+		w.Header().Set("X-Next-Cursor", page.next)
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
@@ -0,0 +1,83 @@
+// list_test.go
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestListPagesAndPresents(t *testing.T) {
+	s := newTestServer(t, newMemoryStore())
+	h := newRouter(s)
+	for i := 0; i < 5; i++ {
+		body := fmt.Sprintf(`{"title": "Article %d", "content": "one two three", "author": {"Name": "Author %d", "Email": "a%d@example.com"}}`, i, i%2, i%2)
+		do(h, "POST", "/article", body)
+	}
+
+	var page []Article
+	w := do(h, "GET", "/articles?author_name=Author%201&sort=-title&limit=1&offset=1", "")
+	decode(t, w, &page)
+	if len(page) != 1 || page[0].Title != "Article 1" {
+		t.Fatalf("got %+v, want Article 1 as the second of Author 1's articles sorted by title descending", page)
+	}
+	if page[0].WordCount != 3 || page[0].Author == nil || page[0].Author.Name != "Author 1" {
+		t.Errorf("the article on the page is not presented: %+v", page[0])
+	}
+	if total := w.Header().Get("X-Total-Count"); total != "2" {
+		t.Errorf("X-Total-Count is %s, want 2", total)
+	}
+}
+
+// TestListSortsIdsByNumber - "10" comes after "9", also across the pages of a cursor
+func TestListSortsIdsByNumber(t *testing.T) {
+	h := newRouter(newTestServer(t, newMemoryStore()))
+	for i := 0; i < 11; i++ {
+		do(h, "POST", "/article", fmt.Sprintf(`{"title": "Article %d"}`, i))
+	}
+
+	var ids []string
+	path := "/articles?sort=id&limit=4"
+	for path != "" {
+		w := do(h, "GET", path, "")
+		var page []Article
+		decode(t, w, &page)
+		for _, a := range page {
+			ids = append(ids, a.Id)
+		}
+		path = ""
+		if next := w.Header().Get("X-Next-Cursor"); next != "" {
+			path = "/articles?limit=4&cursor=" + next
+		}
+	}
+	if got, want := strings.Join(ids, ","), "1,2,3,4,5,6,7,8,9,10,11"; got != want {
+		t.Errorf("sorted by id: got %s, want %s", got, want)
+	}
+
+	var page []Article
+	decode(t, do(h, "GET", "/articles?sort=-id&limit=3", ""), &page)
+	if len(page) != 3 || page[0].Id != "11" || page[2].Id != "9" {
+		t.Errorf("sorted by id descending: got %+v, want 11, 10 and 9 first", page)
+	}
+}
+
+func TestCompareIds(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"9", "10", -1},
+		{"10", "9", 1},
+		{"010", "9", 1},
+		{"10", "10", 0},
+		{"10", "010", 1}, // equal numbers still need an order
+		{"99999999999999999999999", "100000000000000000000000", -1},
+		{"2", "10a", -1}, // numbers go first
+		{"10a", "2", 1},
+		{"a", "b", -1},
+	} {
+		if got := compareIds(c.a, c.b); got != c.want {
+			t.Errorf("compareIds(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
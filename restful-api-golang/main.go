@@ -1,118 +1,517 @@
-// main.go
-package main
-
-import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"log"
-	"math/rand"
-	"net/http"
-	"strconv"
-
-	"github.com/gorilla/mux"
-)
-
-// youtube source: https://www.youtube.com/watch?v=SonwZ6MF5BE
-// morioh source: https://morioh.com/p/9a0e53da7908?fbclid=IwAR05Tp1czeMZCp1s4MHAkRIqABqd-12cRsb3o71894vTGVlT13G91t9kJFk
-
-// Article - Our struct for all articles
-type Article struct {
-	Id      string  `json:"Id"`
-	Title   string  `json:"title"`
-	Desc    string  `json:"desc"`
-	Content string  `json:"content"`
-	Author  *Author `json:"author"`
-}
-
-type Author struct {
-	Name  string `json:"Name"`
-	Email string `json:"Email"`
-}
-
-var Articles []Article
-
-func homePage(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "Welcome to the HomePage!")
-	fmt.Println("Endpoint Hit: homePage")
-}
-
-func returnAllArticles(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Endpoint Hit: returnAllArticles")
-	json.NewEncoder(w).Encode(Articles)
-}
-
-func returnSingleArticle(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	key := vars["id"]
-
-	for _, article := range Articles {
-		if article.Id == key {
-			json.NewEncoder(w).Encode(article)
-		}
-	}
-}
-
-func createNewArticle(w http.ResponseWriter, r *http.Request) {
-	// get the body of our POST request
-	// unmarshal this into a new Article struct
-	// append this to our Articles array.
-	reqBody, _ := ioutil.ReadAll(r.Body)
-	var article Article
-	json.Unmarshal(reqBody, &article)
-	article.Id = strconv.Itoa(rand.Intn(1000000))
-	// update our global Articles array to include
-	// our new Article
-	Articles = append(Articles, article)
-
-	json.NewEncoder(w).Encode(article)
-}
-
-func updateArticle(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	reqBody, _ := ioutil.ReadAll(r.Body)
-	var article Article
-	json.Unmarshal(reqBody, &article) // bien cai request body thanh 1 article co dang struct la Article
-
-	//xoa article cu di va thay bang article moi (delete then add new)
-	for index, article := range Articles {
-		if article.Id == id {
-			Articles = append(Articles[:index], Articles[index+1:]...)
-		}
-	}
-	Articles = append(Articles, article)
-
-	json.NewEncoder(w).Encode(Articles)
-}
-
-func deleteArticle(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	for index, article := range Articles {
-		if article.Id == id {
-			Articles = append(Articles[:index], Articles[index+1:]...)
-		}
-	}
-}
-
-func handleRequests() {
-	myRouter := mux.NewRouter().StrictSlash(true)
-	myRouter.HandleFunc("/", homePage)
-	myRouter.HandleFunc("/articles", returnAllArticles)
-	myRouter.HandleFunc("/article", createNewArticle).Methods("POST")
-	myRouter.HandleFunc("/article/{id}", deleteArticle).Methods("DELETE")
-	myRouter.HandleFunc("/article/{id}", updateArticle).Methods("PUT")
-	myRouter.HandleFunc("/article/{id}", returnSingleArticle)
-	log.Fatal(http.ListenAndServe(":10000", myRouter))
-}
-
-func main() {
-	Articles = []Article{
-		Article{Id: "1", Title: "Hello", Desc: "Article Description", Content: "Article Content", Author: &Author{Name: "Chris Nolan", Email: "nolan@gmail.com"}},
-		Article{Id: "2", Title: "Hello 2", Desc: "Article Description", Content: "Article Content", Author: &Author{Name: "Dave Fincher", Email: "davef@gmail.com"}},
-	}
-	handleRequests()
-}
+// main.go
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// youtube source: https://www.youtube.com/watch?v=SonwZ6MF5BE
+// morioh source: https://morioh.com/p/9a0e53da7908?fbclid=IwAR05Tp1czeMZCp1s4MHAkRIqABqd-12cRsb3o71894vTGVlT13G91t9kJFk
+
+// Article - Our struct for all articles
+type Article struct {
+	Id       string  `json:"Id"`
+	Title    string  `json:"title"`
+	Slug     string  `json:"slug,omitempty"` // derived from Title on every write, see slug.go
+	Desc     string  `json:"desc"`
+	Content  string  `json:"content"`
+	AuthorId string  `json:"authorId,omitempty"` // Email of the author, see authors.go
+	Author   *Author `json:"author,omitempty"`   // filled in from the author store when the article is served
+
+	Tags     []string `json:"tags,omitempty"` // normalized on every write, see tags.go
+	Category string   `json:"category,omitempty"`
+
+	Locale       string                 `json:"locale,omitempty"`       // BCP 47 locale of Title, Desc and Content
+	Translations map[string]Translation `json:"translations,omitempty"` // by BCP 47 locale, see translations.go
+
+	Status    string     `json:"status,omitempty"`    // draft, in_review, published or archived, see workflow.go
+	PublishAt *time.Time `json:"publishAt,omitempty"` // when an article in review goes live, set to the publish time once it is published
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	UpdatedBy string    `json:"updatedBy,omitempty"` // editor of the last change, see revisions.go
+
+	// computed from Content every time the article is served, see markdown.go
+	WordCount   int `json:"wordCount"`
+	ReadingTime int `json:"readingTimeMinutes"`
+}
+
+// Author - authors are their own resource, keyed by Email
+type Author struct {
+	Name  string `json:"Name"`
+	Email string `json:"Email"`
+}
+
+// server - the handlers get the article store injected through this struct
+type server struct {
+	store      *notifyingStore
+	authors    *authorStore
+	index      *searchIndex
+	tags       *tagIndex
+	related    *relatedIndex
+	slugs      *slugIndex
+	revisions  *revisionStore
+	trash      *trashStore
+	comments   *commentStore
+	collab     *collabHub
+	engagement *engagementStore
+	changed    *storeClock
+	locales    []string // the locales articles should be translated to, see returnMissingTranslations
+	ids        IDGenerator
+
+	requirePreconditions bool   // article writes without If-Match get 428
+	defaultLocale        string // the locale of the articles that have none, see localize
+}
+
+// present prepares a stored article for a response: the author is filled in
+// and the content statistics are computed
+func (s *server) present(article Article) Article {
+	article = s.withAuthor(article)
+	article.WordCount, article.ReadingTime = contentStats(article.Content)
+	return article
+}
+
+func (s *server) presentAll(articles []Article) []Article {
+	presented := make([]Article, len(articles))
+	for i, article := range articles {
+		presented[i] = s.present(article)
+	}
+	return presented
+}
+
+func homePage(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "Welcome to the HomePage!")
+	fmt.Println("Endpoint Hit: homePage")
+}
+
+func (s *server) returnAllArticles(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: returnAllArticles")
+	q, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wanted, err := requestedLocales(r)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	articles, err := s.store.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// the filters and sort keys need the authors, the content statistics (a full Markdown parse)
+	// are only computed for the articles on the page
+	for i := range articles {
+		articles[i] = s.withAuthor(localize(articles[i], wanted, s.defaultLocale))
+	}
+	w.Header().Add("Vary", "Accept-Language")
+
+	page := q.apply(articles)
+	page.articles = s.presentAll(page.articles)
+	writePageHeaders(w, r, q, page)
+	render(w, r, page.articles)
+}
+
+func (s *server) returnSingleArticle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["id"]
+
+	article, err := s.visibleArticle(r, key)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	localized, ok := s.localizeFor(w, r, article)
+	if !ok {
+		return
+	}
+	s.engagement.view(key, time.Now()) // a request refused for its locale is no view
+	if notModified(w, r, article, localized.Locale) {
+		return
+	}
+	render(w, r, s.present(localized))
+}
+
+func (s *server) createNewArticle(w http.ResponseWriter, r *http.Request) {
+	// get the body of our POST request
+	// unmarshal this into a new Article struct
+	// add it to our article store.
+	var article Article
+	if err := decodeBody(r, &article); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	normalizeTaxonomy(&article)
+	if err := normalizeLocales(&article); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := s.resolveAuthor(&article); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := applyWorkflow(nil, &article, time.Now().UTC()); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	stampEdit(r, &article, time.Time{})
+
+	article, err := s.createWithNewID(article)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", articleETag(article))
+	render(w, r, s.present(article))
+}
+
+func (s *server) updateArticle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var article Article
+	// bien cai request body thanh 1 article co dang struct la Article
+	if err := decodeBody(r, &article); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	normalizeTaxonomy(&article)
+	if err := s.resolveAuthor(&article); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// thay article cu bang article moi ngay tai cho, giu nguyen id tren path (replace in place, keep the path id)
+	article, err := s.store.UpdateIf(id, func(current Article) (Article, error) {
+		if err := s.checkIfMatch(r, current); err != nil {
+			return Article{}, err
+		}
+		// a PUT without status or publishAt leaves them as they are, the same for the translations
+		if article.PublishAt == nil {
+			article.PublishAt = current.PublishAt
+		}
+		keepTranslations(current, &article)
+		if err := normalizeLocales(&article); err != nil {
+			return Article{}, err
+		}
+		if err := applyWorkflow(&current, &article, time.Now().UTC()); err != nil {
+			return Article{}, err
+		}
+		article.Slug = s.slugs.claim(id, article.Title)
+		stampEdit(r, &article, current.CreatedAt)
+		return article, nil
+	})
+	if err != nil {
+		s.slugs.release(id) // the write failed after the claim, e.g. an unknown author
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", articleETag(article))
+	render(w, r, s.present(article))
+}
+
+func (s *server) deleteArticle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// deleting moves the article to the trash, see trash.go
+	entry, err := s.trashArticle(r, id, func(current Article) error {
+		return s.checkIfMatch(r, current)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	render(w, r, entry)
+}
+
+// writeStoreError maps the ArticleStore and workflow errors onto HTTP status codes
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case err == ErrArticleNotFound, err == ErrTranslationNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err == ErrArticleExists, errors.Is(err, ErrInvalidTransition):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err == ErrPreconditionFailed:
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case err == ErrPreconditionRequired:
+		http.Error(w, err.Error(), http.StatusPreconditionRequired)
+	case err == ErrInvalidStatus, errors.Is(err, ErrInvalidLocale), errors.Is(err, ErrUnknownAuthor):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err == ErrPublishAtFuture:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// newServer wires the stores together. Every article write goes through a notifying store,
+// so the search and related indexes, the tag counts, the slugs, the revision history and the comments follow along.
+func newServer(store ArticleStore, authors *authorStore, revisions *revisionStore, trash *trashStore, comments *commentStore, slugs *slugIndex, engagement *engagementStore, ids IDGenerator) (*server, error) {
+	if err := migrateAuthors(store, authors); err != nil {
+		return nil, fmt.Errorf("unable to migrate the embedded authors: %v", err)
+	}
+	if err := migrateSlugs(store, slugs); err != nil {
+		return nil, fmt.Errorf("unable to give the articles slugs: %v", err)
+	}
+
+	notifying := newNotifyingStore(store)
+	notifying.checkAuthor = authors.check
+	index := newSearchIndex()
+	tags := newTagIndex()
+	related := newRelatedIndex()
+	collab := newCollabHub()
+	collab.store = notifying
+	changed := &storeClock{}
+	for _, l := range []storeListener{index, tags, related, slugs, revisions, comments, collab, changed} {
+		if err := notifying.listen(l); err != nil {
+			return nil, err
+		}
+	}
+	authors.changed = changed.touch // a renamed author changes the feeds of their articles
+	s := &server{store: notifying, authors: authors, index: index, tags: tags, related: related, slugs: slugs, revisions: revisions, trash: trash, comments: comments, collab: collab, engagement: engagement, changed: changed, ids: ids}
+	if err := s.seedIDs(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newRouter registers the routes, the ones answering with data go through negotiate (see negotiate.go),
+// the feeds, the HTML page, the diff and the export write their own formats, collab is a WebSocket
+func newRouter(s *server) *mux.Router {
+	myRouter := mux.NewRouter().StrictSlash(true)
+	myRouter.HandleFunc("/", homePage)
+	myRouter.HandleFunc("/articles/search", negotiate(s.searchArticles)).Methods("GET")
+	myRouter.HandleFunc("/articles/trending", negotiate(s.returnTrendingArticles)).Methods("GET")
+	myRouter.HandleFunc("/articles/export", s.exportArticles).Methods("GET")
+	myRouter.HandleFunc("/articles/import", negotiate(s.importArticles)).Methods("POST")
+	myRouter.HandleFunc("/articles/by-slug/{slug}", negotiate(s.returnArticleBySlug)).Methods("GET")
+	myRouter.HandleFunc("/articles", negotiate(s.returnAllArticles))
+	myRouter.HandleFunc("/article", negotiate(s.createNewArticle)).Methods("POST")
+	myRouter.HandleFunc("/article/{id}", negotiate(s.deleteArticle)).Methods("DELETE")
+	myRouter.HandleFunc("/article/{id}", negotiate(s.updateArticle)).Methods("PUT")
+	myRouter.HandleFunc("/article/{id}", negotiate(s.patchArticle)).Methods("PATCH")
+	myRouter.HandleFunc("/article/{id}", negotiate(s.returnSingleArticle))
+	myRouter.HandleFunc("/article/{id}/tags", negotiate(s.replaceArticleTags)).Methods("PUT")
+	myRouter.HandleFunc("/article/{id}/status", negotiate(s.changeArticleStatus)).Methods("PUT")
+	myRouter.HandleFunc("/article/{id}/collab", s.collabArticle).Methods("GET")
+	myRouter.HandleFunc("/article/{id}/like", negotiate(s.likeArticle)).Methods("POST")
+	myRouter.HandleFunc("/article/{id}/translations", negotiate(s.returnArticleTranslations)).Methods("GET")
+	myRouter.HandleFunc("/article/{id}/translations/missing", negotiate(s.returnMissingTranslations)).Methods("GET")
+	myRouter.HandleFunc("/article/{id}/translations/{locale}", negotiate(s.returnArticleTranslation)).Methods("GET")
+	myRouter.HandleFunc("/article/{id}/translations/{locale}", negotiate(s.putArticleTranslation)).Methods("PUT")
+	myRouter.HandleFunc("/article/{id}/translations/{locale}", negotiate(s.deleteArticleTranslation)).Methods("DELETE")
+	myRouter.HandleFunc("/article/{id}/related", negotiate(s.returnRelatedArticles)).Methods("GET")
+	myRouter.HandleFunc("/article/{id}/comments", negotiate(s.returnArticleComments)).Methods("GET")
+	myRouter.HandleFunc("/article/{id}/comments", negotiate(s.createComment)).Methods("POST")
+	myRouter.HandleFunc("/article/{id}/comments/{commentId}", negotiate(s.returnComment)).Methods("GET")
+	myRouter.HandleFunc("/article/{id}/comments/{commentId}", negotiate(s.updateComment)).Methods("PUT")
+	myRouter.HandleFunc("/article/{id}/comments/{commentId}", negotiate(s.deleteComment)).Methods("DELETE")
+	myRouter.HandleFunc("/tags", negotiate(s.returnTags)).Methods("GET")
+	myRouter.HandleFunc("/article/{id}/html", s.returnArticleHTML).Methods("GET")
+	myRouter.HandleFunc("/article/{id}/revisions", negotiate(s.returnArticleRevisions)).Methods("GET")
+	myRouter.HandleFunc("/article/{id}/revisions/{n}", negotiate(s.returnArticleRevision)).Methods("GET")
+	myRouter.HandleFunc("/article/{id}/diff", s.diffArticleRevisions).Methods("GET")
+	myRouter.HandleFunc("/article/{id}/revert/{n}", negotiate(s.revertArticle)).Methods("POST")
+	myRouter.HandleFunc("/feed.atom", s.atomFeed).Methods("GET", "HEAD")
+	myRouter.HandleFunc("/feed.rss", s.rssFeed).Methods("GET", "HEAD")
+	myRouter.HandleFunc("/sitemap.xml", s.sitemap).Methods("GET", "HEAD")
+	myRouter.HandleFunc("/trash", negotiate(s.returnTrash)).Methods("GET")
+	myRouter.HandleFunc("/trash/{id}/restore", negotiate(s.restoreArticle)).Methods("POST")
+	myRouter.HandleFunc("/trash/{id}", negotiate(s.purgeArticle)).Methods("DELETE")
+	myRouter.HandleFunc("/authors", negotiate(s.returnAllAuthors)).Methods("GET")
+	myRouter.HandleFunc("/authors", negotiate(s.createNewAuthor)).Methods("POST")
+	myRouter.HandleFunc("/authors/{email}", negotiate(s.returnSingleAuthor)).Methods("GET")
+	myRouter.HandleFunc("/authors/{email}", negotiate(s.updateAuthor)).Methods("PUT")
+	myRouter.HandleFunc("/authors/{email}", negotiate(s.deleteAuthor)).Methods("DELETE")
+	myRouter.HandleFunc("/authors/{email}/articles", negotiate(s.returnAuthorArticles)).Methods("GET")
+	return myRouter
+}
+
+func handleRequests(s *server) {
+	log.Fatal(http.ListenAndServe(":10000", newRouter(s)))
+}
+
+// seedArticles - the two articles every fresh store starts with,
+// their embedded authors are moved to the author store by migrateAuthors
+func seedArticles() []Article {
+	now := time.Now().UTC()
+	return []Article{
+		Article{Id: "1", Title: "Hello", Desc: "Article Description", Content: "Article Content", Author: &Author{Name: "Chris Nolan", Email: "nolan@gmail.com"}, Status: StatusPublished, PublishAt: &now, CreatedAt: now, UpdatedAt: now},
+		Article{Id: "2", Title: "Hello 2", Desc: "Article Description", Content: "Article Content", Author: &Author{Name: "Dave Fincher", Email: "davef@gmail.com"}, Status: StatusPublished, PublishAt: &now, CreatedAt: now, UpdatedAt: now},
+	}
+}
+
+// storageFlags registers the flags that pick where the articles live, the server and export-site share them
+func storageFlags(flags *flag.FlagSet) (mode, dataDir, contentDir *string) {
+	mode = flags.String("mode", "seed", "storage mode: seed (in-memory, re-seeded on every start), persistent, git (a git repository below -data, one commit per write) or markdown (in-memory, loaded from and kept in line with -content)")
+	dataDir = flags.String("data", "data", "directory for the journal and snapshots in persistent mode, for the repository in git mode")
+	contentDir = flags.String("content", "content", "directory of Markdown files with YAML front matter in markdown mode")
+	return mode, dataDir, contentDir
+}
+
+// openServer opens the article store of the mode and the side stores next to it.
+// In markdown mode it also loads the directory, the returned markdownDir can then watch it.
+// readOnly loads everything into memory and never writes to -data, for export-site.
+func openServer(mode, dataDir, contentDir, idKind string, readOnly bool) (*server, *markdownDir, error) {
+	ids, err := newIDGenerator(idKind)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// dataPath - where a side store keeps its file, empty in seed mode
+	dataPath := func(name string) string { return "" }
+
+	var (
+		store ArticleStore
+		repo  *gitStore
+	)
+	switch mode {
+	case "seed":
+		store = newMemoryStore(seedArticles()...)
+	case "persistent":
+		dataPath = func(name string) string { return filepath.Join(dataDir, name) }
+		if readOnly {
+			if store, err = readFileStore(dataDir, seedArticles()...); err != nil {
+				return nil, nil, fmt.Errorf("unable to read the data directory %s: %v", dataDir, err)
+			}
+			break
+		}
+		fs, err := openFileStore(dataDir, seedArticles()...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to open the data directory %s: %v", dataDir, err)
+		}
+		store = fs
+	case "git":
+		dataPath = func(name string) string { return filepath.Join(dataDir, name) }
+		if readOnly {
+			if store, err = readGitStore(filepath.Join(dataDir, "repo"), seedArticles()...); err != nil {
+				return nil, nil, fmt.Errorf("unable to read the git repository in %s: %v", dataDir, err)
+			}
+			break
+		}
+		repo, err = openGitStore(filepath.Join(dataDir, "repo"), seedArticles()...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to open the git repository in %s: %v", dataDir, err)
+		}
+		store = repo
+	case "markdown":
+		store = newMemoryStore()
+	default:
+		return nil, nil, fmt.Errorf("unknown mode %q, use seed, persistent, git or markdown", mode)
+	}
+	authors, err := newAuthorStore(dataPath("authors.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if repo != nil {
+		repo.authorOf = authors.Get // commits name the author, the articles only reference them
+	}
+	revisionsPath := dataPath("revisions.ndjson")
+	if readOnly {
+		revisionsPath = "" // opening the log cuts off a torn entry, and nothing read-only needs the history
+	}
+	revisions, err := newRevisionStore(revisionsPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	trash, err := newTrashStore(dataPath("trash.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	comments, err := newCommentStore(dataPath("comments.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	slugs, err := newSlugIndex(dataPath("slugs.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	engagement, err := newEngagementStore(dataPath("engagement.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if readOnly {
+		// loaded from -data, from here on they are kept in memory only: the migrations
+		// in newServer write to them, and those writes must not reach the files
+		authors.path, trash.path, comments.path, slugs.path, engagement.path = "", "", "", "", ""
+	}
+
+	s, err := newServer(store, authors, revisions, trash, comments, slugs, engagement, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mode != "markdown" {
+		return s, nil, nil
+	}
+	dir, err := s.loadMarkdownDir(contentDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load the Markdown directory %s: %v", contentDir, err)
+	}
+	if err := s.seedIDs(); err != nil { // the front matter may have set numeric ids
+		return nil, nil, err
+	}
+	return s, dir, nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export-site" {
+		if err := exportSite(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	mode, dataDir, contentDir := storageFlags(flag.CommandLine)
+	snapshotInterval := flag.Duration("snapshot-interval", time.Minute, "how often the journal is compacted into a snapshot in persistent mode")
+	requireIfMatch := flag.Bool("require-if-match", false, "answer article writes without an If-Match header with 428 Precondition Required")
+	publishInterval := flag.Duration("publish-interval", 30*time.Second, "how often the scheduler looks for articles whose publishAt has passed")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "how long deleted articles stay in the trash before they are purged")
+	statsInterval := flag.Duration("stats-flush-interval", 30*time.Second, "how often the view and like counters are written to -data")
+	locales := flag.String("locales", "", "comma separated BCP 47 locales the articles should be translated to, e.g. en-US,vi-VN")
+	defaultLocale := flag.String("default-locale", "en", "BCP 47 locale of the articles that do not name one, Accept-Language is matched against it; empty leaves it unknown")
+	idKind := flag.String("ids", "ulid", "article id generator: ulid, uuidv7 or sequence")
+	flag.Parse()
+
+	s, dir, err := openServer(*mode, *dataDir, *contentDir, *idKind, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.requirePreconditions = *requireIfMatch
+	if s.locales, err = parseLocales(*locales); err != nil {
+		log.Fatal(err)
+	}
+	if *defaultLocale != "" {
+		if s.defaultLocale, err = canonicalLocale(*defaultLocale); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if fs, ok := s.store.ArticleStore.(*fileStore); ok {
+		go fs.compactEvery(*snapshotInterval)
+	}
+	go s.purgeEvery(time.Minute, *trashRetention)
+	go s.engagement.flushEvery(*statsInterval)
+	go s.publishEvery(*publishInterval)
+	if dir != nil {
+		go func() {
+			if err := dir.watch(); err != nil {
+				log.Printf("markdown: no live updates from %s: %v", *contentDir, err)
+			}
+		}()
+	}
+	handleRequests(s)
+}
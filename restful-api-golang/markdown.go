@@ -0,0 +1,189 @@
+// markdown.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+)
+
+// average reading speed used for readingTimeMinutes
+const wordsPerMinute = 200
+
+// markdown renders CommonMark plus the GFM extensions (tables, strikethrough, autolinks, task lists).
+// Raw HTML is let through here and cleaned up by sanitizer afterwards.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+	goldmark.WithRendererOptions(goldmarkhtml.WithUnsafe()),
+)
+
+// sanitizer - allow-list policy for user generated content: no scripts, no event handlers,
+// no javascript: urls. The heading ids are kept so the table of contents links work.
+var sanitizer = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("id").OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("type").Matching(bluemonday.SpaceSeparatedTokens).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	return p
+}()
+
+// tocEntry - one heading of the generated table of contents
+type tocEntry struct {
+	Level int    `json:"level"`
+	Id    string `json:"id"`
+	Text  string `json:"text"`
+}
+
+// renderedArticle - what GET /article/{id}/html returns when JSON is asked for
+type renderedArticle struct {
+	Id          string     `json:"Id"`
+	Title       string     `json:"title"`
+	HTML        string     `json:"html"`
+	TOC         []tocEntry `json:"toc"`
+	WordCount   int        `json:"wordCount"`
+	ReadingTime int        `json:"readingTimeMinutes"`
+}
+
+// renderMarkdown turns the content into sanitized HTML and collects the headings for the TOC
+func renderMarkdown(content string) (string, []tocEntry, error) {
+	source := []byte(content)
+	doc := markdown.Parser().Parse(text.NewReader(source))
+
+	toc := []tocEntry{}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		entry := tocEntry{Level: heading.Level, Text: plainText(heading, source)}
+		if id, ok := heading.AttributeString("id"); ok {
+			if b, ok := id.([]byte); ok {
+				entry.Id = string(b)
+			}
+		}
+		toc = append(toc, entry)
+		return ast.WalkSkipChildren, nil
+	})
+
+	var buf bytes.Buffer
+	if err := markdown.Renderer().Render(&buf, source, doc); err != nil {
+		return "", nil, err
+	}
+	return sanitizer.Sanitize(buf.String()), toc, nil
+}
+
+// plainText collects the text below a node, without the markdown around it
+func plainText(n ast.Node, source []byte) string {
+	var b strings.Builder
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch t := c.(type) {
+		case *ast.Text:
+			b.Write(t.Segment.Value(source))
+			if t.SoftLineBreak() || t.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.Paragraph, *ast.Heading, *ast.ListItem:
+			b.WriteByte(' ') // keep words of neighbouring blocks apart
+		case *ast.String:
+			b.Write(t.Value)
+		case *ast.CodeSpan:
+			for child := t.FirstChild(); child != nil; child = child.NextSibling() {
+				if seg, ok := child.(*ast.Text); ok {
+					b.Write(seg.Segment.Value(source))
+				}
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(b.String())
+}
+
+// contentStats counts the words of the content (not the markdown or HTML around them)
+// and how long it takes to read them
+func contentStats(content string) (words, minutes int) {
+	source := []byte(content)
+	doc := markdown.Parser().Parse(text.NewReader(source))
+	words = len(tokenize(plainText(doc, source)))
+	if words == 0 {
+		return 0, 0
+	}
+	return words, int(math.Ceil(float64(words) / wordsPerMinute))
+}
+
+// page used when the rendered article is asked for as a whole HTML document
+var articlePage = template.Must(template.New("article").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<article>
+<h1>{{.Title}}</h1>
+<p class="meta">{{.WordCount}} words, {{.ReadingTime}} min read</p>
+{{if .TOC}}<nav class="toc"><ul>
+{{range .TOC}}<li class="toc-h{{.Level}}"><a href="#{{.Id}}">{{.Text}}</a></li>
+{{end}}</ul></nav>{{end}}
+{{.Body}}
+</article>
+</body>
+</html>
+`))
+
+// GET /article/{id}/html - the content rendered to sanitized HTML.
+// Browsers get a full page, Accept: application/json gets the HTML and the TOC as JSON.
+func (s *server) returnArticleHTML(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: returnArticleHTML")
+	article, err := s.visibleArticle(r, mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	body, toc, err := renderMarkdown(article.Content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	words, minutes := contentStats(article.Content)
+	rendered := renderedArticle{
+		Id:          article.Id,
+		Title:       article.Title,
+		HTML:        body,
+		TOC:         toc,
+		WordCount:   words,
+		ReadingTime: minutes,
+	}
+
+	if prefersData(r) {
+		render(w, r, rendered)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	articlePage.Execute(w, struct {
+		renderedArticle
+		Body template.HTML
+	}{rendered, template.HTML(body)}) // already sanitized
+}
+
+// prefersData reports whether the first media type in Accept is one of the data formats, see negotiate.go
+func prefersData(r *http.Request) bool {
+	accept := strings.Split(r.Header.Get("Accept"), ",")[0]
+	mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(accept))
+	return mediaType != "" && formatFor(mediaType) != nil
+}
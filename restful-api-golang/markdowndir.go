@@ -0,0 +1,349 @@
+// markdowndir.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// how long a file has to stay quiet before it is read again, editors often write a file in several steps
+const markdownSettle = 200 * time.Millisecond
+
+// frontMatter - the YAML block between "---" lines at the top of a Markdown file
+type frontMatter struct {
+	Id        string      `yaml:"id"`    // defaults to the path of the file, see markdownID
+	Title     string      `yaml:"title"` // defaults to the first "# " heading, then to the file name
+	Desc      string      `yaml:"desc"`
+	Author    interface{} `yaml:"author"` // "jane@example.com", "Jane Doe <jane@example.com>" or {name: ..., email: ...}
+	Tags      []string    `yaml:"tags"`
+	Category  string      `yaml:"category"`
+	Status    string      `yaml:"status"`
+	PublishAt *time.Time  `yaml:"publishAt"`
+	Date      *time.Time  `yaml:"date"` // becomes createdAt
+}
+
+// splitFrontMatter separates the front matter from the Markdown body, a file without one is all body
+func splitFrontMatter(data []byte) (frontMatter, string, error) {
+	var fm frontMatter
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // UTF-8 byte order mark
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if !strings.HasPrefix(text, "---\n") {
+		return fm, text, nil
+	}
+	// the front matter ends at the first line that is --- and nothing else, it may be empty
+	rest := text[4:]
+	for start := 0; start < len(rest); {
+		line, next := rest[start:], len(rest)
+		if end := strings.IndexByte(line, '\n'); end >= 0 {
+			line, next = line[:end], start+end+1
+		}
+		if strings.TrimRight(line, " \t") == "---" {
+			if err := yaml.Unmarshal([]byte(rest[:start]), &fm); err != nil {
+				return fm, "", fmt.Errorf("front matter: %v", err)
+			}
+			return fm, rest[next:], nil
+		}
+		start = next
+	}
+	return fm, "", errors.New("the front matter is not closed with ---")
+}
+
+// parseAuthor reads the author of the front matter
+func parseAuthor(v interface{}) (*Author, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if strings.Contains(v, "<") {
+			addr, err := mail.ParseAddress(v)
+			if err != nil {
+				return nil, fmt.Errorf("author: %v", err)
+			}
+			return &Author{Name: addr.Name, Email: addr.Address}, nil
+		}
+		return &Author{Email: strings.TrimSpace(v)}, nil
+	case map[string]interface{}:
+		name, _ := v["name"].(string)
+		email, _ := v["email"].(string)
+		return &Author{Name: name, Email: email}, nil
+	}
+	return nil, errors.New("author must be an email address or {name, email}")
+}
+
+// firstHeading returns the text of the first "# " heading of a Markdown body
+func firstHeading(body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "# ") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "# "))
+		}
+	}
+	return ""
+}
+
+// markdownID - the article id of a file: its path below the directory without .md, "/" replaced by "-"
+func markdownID(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	rel = strings.TrimSuffix(filepath.ToSlash(rel), filepath.Ext(rel))
+	return strings.ReplaceAll(rel, "/", "-")
+}
+
+func isMarkdown(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return (ext == ".md" || ext == ".markdown") && !strings.HasPrefix(filepath.Base(path), ".")
+}
+
+// markdownDir keeps the store in line with a directory of Markdown files:
+// every file is one article, creating, editing or deleting a file does the same to the article.
+// Changes made through the API to such an article last until the file changes again.
+type markdownDir struct {
+	s      *server
+	root   string
+	mu     sync.Mutex
+	ids    map[string]string      // file path -> id of the article it holds
+	timers map[string]*time.Timer // files waiting for markdownSettle
+}
+
+// loadMarkdownDir reads every Markdown file below root into the store
+func (s *server) loadMarkdownDir(root string) (*markdownDir, error) {
+	d := &markdownDir{s: s, root: root, ids: map[string]string{}, timers: map[string]*time.Timer{}}
+	loaded := 0
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !isMarkdown(path) {
+			return nil
+		}
+		if err := d.sync(path); err != nil {
+			log.Printf("markdown: %s: %v", path, err)
+			return nil
+		}
+		loaded++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("markdown: loaded %d article(s) from %s", loaded, root)
+	return d, nil
+}
+
+// sync brings the article of one file in line with the file, a missing file deletes its article
+func (d *markdownDir) sync(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d.remove(path)
+	}
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	fm, body, err := splitFrontMatter(data)
+	if err != nil {
+		return err
+	}
+	id := fm.Id
+	if id == "" {
+		id = markdownID(d.root, path)
+	}
+
+	d.mu.Lock()
+	previous, known := d.ids[path]
+	for other, otherID := range d.ids {
+		if other != path && otherID == id {
+			d.mu.Unlock()
+			return fmt.Errorf("id %q is already used by %s", id, other)
+		}
+	}
+	d.mu.Unlock()
+	if known && previous != id {
+		// the id in the front matter changed, the old article goes
+		if err := d.s.store.Delete(previous); err != nil && err != ErrArticleNotFound {
+			return err
+		}
+	}
+
+	article := Article{
+		Id:        id,
+		Title:     fm.Title,
+		Desc:      fm.Desc,
+		Content:   body,
+		Tags:      fm.Tags,
+		Category:  fm.Category,
+		Status:    fm.Status,
+		PublishAt: fm.PublishAt,
+		UpdatedAt: info.ModTime().UTC(),
+		UpdatedBy: "markdown",
+	}
+	if article.Title == "" {
+		article.Title = firstHeading(body)
+	}
+	if article.Title == "" {
+		article.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if article.Author, err = parseAuthor(fm.Author); err != nil {
+		return err
+	}
+	normalizeTaxonomy(&article)
+	if err := d.s.resolveAuthor(&article); err != nil {
+		return err
+	}
+	// the file decides the status, like an import the transitions are not checked
+	if err := applyWorkflow(nil, &article, time.Now().UTC()); err != nil {
+		return err
+	}
+	article.Slug = d.s.slugs.claim(id, article.Title)
+
+	current, err := d.s.store.Get(id)
+	switch {
+	case err == ErrArticleNotFound:
+		article.CreatedAt = article.UpdatedAt
+		if fm.Date != nil {
+			article.CreatedAt = fm.Date.UTC()
+		}
+		_, err = d.s.store.Create(article)
+		if err != nil {
+			d.s.slugs.release(id)
+		}
+	case err == nil:
+		article.CreatedAt = current.CreatedAt
+		if fm.Date != nil {
+			article.CreatedAt = fm.Date.UTC()
+		}
+		_, err = d.s.store.Update(id, article)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.ids[path] = id
+	d.mu.Unlock()
+	return nil
+}
+
+// remove deletes the article of a file that is gone
+func (d *markdownDir) remove(path string) error {
+	d.mu.Lock()
+	id, known := d.ids[path]
+	delete(d.ids, path)
+	d.mu.Unlock()
+	if !known {
+		return nil
+	}
+	if err := d.s.store.Delete(id); err != nil && err != ErrArticleNotFound {
+		return err
+	}
+	log.Printf("markdown: %s was removed, deleted article %s", path, id)
+	return nil
+}
+
+// removeDir deletes the articles of every file below a directory that is gone
+func (d *markdownDir) removeDir(dir string) {
+	d.mu.Lock()
+	var gone []string
+	for path := range d.ids {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			gone = append(gone, path)
+		}
+	}
+	d.mu.Unlock()
+	for _, path := range gone {
+		if err := d.remove(path); err != nil {
+			log.Printf("markdown: %s: %v", path, err)
+		}
+	}
+}
+
+// later syncs a file once it has been quiet for markdownSettle
+func (d *markdownDir) later(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if t, ok := d.timers[path]; ok {
+		t.Reset(markdownSettle)
+		return
+	}
+	d.timers[path] = time.AfterFunc(markdownSettle, func() {
+		d.mu.Lock()
+		delete(d.timers, path)
+		d.mu.Unlock()
+		if err := d.sync(path); err != nil {
+			log.Printf("markdown: %s: %v", path, err)
+		}
+	})
+}
+
+// watch follows the directory with inotify (fsnotify) until the watcher fails.
+// fsnotify does not watch subdirectories by itself, so every directory is added, new ones as they appear.
+func (d *markdownDir) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	addTree := func(root string) {
+		filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err == nil && entry.IsDir() {
+				if err := watcher.Add(path); err != nil {
+					log.Printf("markdown: unable to watch %s: %v", path, err)
+				}
+			}
+			return nil
+		})
+	}
+	addTree(d.root)
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					addTree(event.Name)
+					// files may have landed in it before the watch was in place
+					filepath.WalkDir(event.Name, func(path string, entry fs.DirEntry, err error) error {
+						if err == nil && !entry.IsDir() && isMarkdown(path) {
+							d.later(path)
+						}
+						return nil
+					})
+					continue
+				}
+			}
+			if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+				d.removeDir(event.Name)
+			}
+			if isMarkdown(event.Name) {
+				d.later(event.Name)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("markdown: watching %s: %v", d.root, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// markdowndir_test.go
+package main
+
+import "testing"
+
+func TestSplitFrontMatter(t *testing.T) {
+	for _, c := range []struct {
+		name, in, title, body string
+	}{
+		{"no front matter", "# Hello\n", "", "# Hello\n"},
+		{"front matter", "---\ntitle: Hello\n---\nBody\n", "Hello", "Body\n"},
+		{"empty front matter", "---\n---\nBody\n", "", "Body\n"},
+		{"closing line at the end", "---\ntitle: Hello\n---", "Hello", ""},
+		{"rule in the body", "---\ntitle: Hello\n---\nBody\n\n---\n\nMore\n", "Hello", "Body\n\n---\n\nMore\n"},
+		{"CRLF and trailing space", "---\r\ntitle: Hello\r\n--- \r\nBody\r\n", "Hello", "Body\n"},
+	} {
+		fm, body, err := splitFrontMatter([]byte(c.in))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if fm.Title != c.title || body != c.body {
+			t.Errorf("%s: got title %q body %q, want %q %q", c.name, fm.Title, body, c.title, c.body)
+		}
+	}
+
+	for _, in := range []string{"---\ntitle: Hello\n", "---\ntitle: Hello\n----\nBody\n"} {
+		if _, _, err := splitFrontMatter([]byte(in)); err == nil {
+			t.Errorf("front matter without a closing line %q was accepted", in)
+		}
+	}
+}
@@ -0,0 +1,600 @@
+// negotiate.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// format - one of the representations the API speaks, for responses (Accept) and request bodies (Content-Type).
+// JSON stays the reference: the other formats are made from the JSON form, so every format
+// uses the same field names in the same order.
+type format struct {
+	mediaTypes []string // the first one is sent as Content-Type
+	encode     func(w io.Writer, v interface{}) error
+	decode     func(data []byte, v interface{}) error
+}
+
+// formats in order of preference, the first one wins a tie and answers requests without Accept
+var formats = []*format{
+	{[]string{"application/json"}, writeJSON, json.Unmarshal},
+	{[]string{"application/xml", "text/xml"}, writeXML, readXML},
+	{[]string{"application/yaml", "application/x-yaml", "text/yaml"}, writeYAML, readYAML},
+	{[]string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}, writeMsgpack, readMsgpack},
+}
+
+func supportedTypes() string {
+	var types []string
+	for _, f := range formats {
+		types = append(types, f.mediaTypes[0])
+	}
+	return strings.Join(types, ", ")
+}
+
+// formatFor returns the format of a Content-Type, a missing one is taken as JSON
+func formatFor(mediaType string) *format {
+	if mediaType == "" {
+		return formats[0]
+	}
+	for _, f := range formats {
+		for _, t := range f.mediaTypes {
+			if t == mediaType {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType, q})
+	}
+	return ranges
+}
+
+// quality - the q the most specific matching range of Accept gives the format, 0 if none matches
+func (f *format) quality(ranges []acceptRange) float64 {
+	q, specificity := 0.0, -1
+	for _, ar := range ranges {
+		for _, t := range f.mediaTypes {
+			s := -1
+			switch {
+			case ar.mediaType == t:
+				s = 2
+			case strings.HasSuffix(ar.mediaType, "/*") && strings.HasPrefix(t, strings.TrimSuffix(ar.mediaType, "*")):
+				s = 1
+			case ar.mediaType == "*/*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = ar.q, s
+			}
+		}
+	}
+	return q
+}
+
+// negotiateFormat picks the format the Accept header likes best, ok is false when it accepts none of them
+func negotiateFormat(accept string) (f *format, ok bool) {
+	if strings.TrimSpace(accept) == "" {
+		return formats[0], true
+	}
+	ranges := parseAccept(accept)
+	best := 0.0
+	for _, candidate := range formats {
+		if q := candidate.quality(ranges); q > best {
+			f, best = candidate, q
+		}
+	}
+	return f, f != nil
+}
+
+type formatKey struct{}
+
+// negotiate wraps the handlers that answer with data: the format is picked before the handler runs,
+// so a request nobody can answer is refused with 406 before it changes anything
+func negotiate(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, ok := negotiateFormat(r.Header.Get("Accept"))
+		if !ok {
+			http.Error(w, "none of the accepted media types can be produced, use one of "+supportedTypes(), http.StatusNotAcceptable)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), formatKey{}, f)))
+	}
+}
+
+// formatOf - the format negotiate picked, handlers outside negotiate get the best one for Accept or JSON
+func formatOf(r *http.Request) *format {
+	if f, ok := r.Context().Value(formatKey{}).(*format); ok {
+		return f
+	}
+	if f, ok := negotiateFormat(r.Header.Get("Accept")); ok {
+		return f
+	}
+	return formats[0]
+}
+
+// render writes v as the response in the negotiated format
+func render(w http.ResponseWriter, r *http.Request, v interface{}) {
+	renderStatus(w, r, http.StatusOK, v)
+}
+
+func renderStatus(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	f := formatOf(r)
+	var buf bytes.Buffer
+	if err := f.encode(&buf, v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", f.mediaTypes[0])
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// bodyError - a request body that cannot be read, with the status to answer
+type bodyError struct {
+	status int
+	err    error
+}
+
+func (e *bodyError) Error() string { return e.err.Error() }
+
+// decodeBody reads the request body in the format of its Content-Type into v
+func decodeBody(r *http.Request, v interface{}) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	f := formatFor(mediaType)
+	if f == nil {
+		return &bodyError{http.StatusUnsupportedMediaType, fmt.Errorf("cannot read a %s body, send one of %s", mediaType, supportedTypes())}
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = f.decode(data, v)
+	}
+	if err != nil {
+		return &bodyError{http.StatusBadRequest, err}
+	}
+	return nil
+}
+
+func writeBodyError(w http.ResponseWriter, err error) {
+	if be, ok := err.(*bodyError); ok {
+		http.Error(w, be.Error(), be.status)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// object - a JSON object that keeps its keys in order, so XML and YAML list the fields like JSON does
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// toTree turns v into what its JSON form decodes to: *object, []interface{}, string, int64, float64, bool or nil
+func toTree(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return readTree(dec)
+}
+
+func readTree(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '{' {
+			o := &object{values: map[string]interface{}{}}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := readTree(dec)
+				if err != nil {
+					return nil, err
+				}
+				o.keys = append(o.keys, key.(string))
+				o.values[key.(string)] = value
+			}
+			_, err := dec.Token() // }
+			return o, err
+		}
+		items := []interface{}{}
+		for dec.More() {
+			item, err := readTree(dec)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err := dec.Token() // ]
+		return items, err
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	}
+	return tok, nil
+}
+
+// fromTree decodes a tree read from another format into v by way of JSON
+func fromTree(tree interface{}, v interface{}) error {
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// rootName names the XML document element after the Go type: Article -> article, []Article -> articles
+func rootName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return "response"
+	}
+	plural := false
+	if t.Kind() == reflect.Slice {
+		t, plural = t.Elem(), true
+	}
+	name := t.Name()
+	if name == "" || t.Kind() == reflect.Map {
+		return "response"
+	}
+	name = string(unicode.ToLower(rune(name[0]))) + name[1:]
+	if !plural {
+		return name
+	}
+	if strings.HasSuffix(name, "y") {
+		return strings.TrimSuffix(name, "y") + "ies"
+	}
+	return name + "s"
+}
+
+// singular names the elements of an XML list after the list: tags -> tag, replies -> reply
+func singular(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies"):
+		return strings.TrimSuffix(name, "ies") + "y"
+	case strings.HasSuffix(name, "s") && len(name) > 1:
+		return strings.TrimSuffix(name, "s")
+	}
+	return "item"
+}
+
+var xmlNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+
+func writeXML(w io.Writer, v interface{}) error {
+	tree, err := toTree(v)
+	if err != nil {
+		return err
+	}
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := xmlElement(enc, rootName(v), tree); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// xmlElement writes one value, keys that are no XML name become <entry key="...">
+func xmlElement(enc *xml.Encoder, name string, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !xmlNamePattern.MatchString(name) {
+		start = xml.StartElement{Name: xml.Name{Local: "entry"}, Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}}}
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case *object:
+		for _, key := range v.keys {
+			if err := xmlElement(enc, key, v.values[key]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		item := singular(name)
+		for _, x := range v {
+			if err := xmlElement(enc, item, x); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// readXML reads a document written the way writeXML writes one.
+// XML has no types: the text of a leaf is read as the number or boolean the field it goes into holds,
+// text that does not fit is an error like a string in a number field of a JSON body.
+func readXML(data []byte, v interface{}) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			tree, err := readXMLElement(dec, start)
+			if err != nil {
+				return err
+			}
+			return fromTree(typeXMLLeaves(tree, reflect.TypeOf(v)), v)
+		}
+	}
+}
+
+// typeXMLLeaves turns the text leaves of tree into numbers and booleans where t, the type the tree
+// is decoded into, has number and bool fields. Text that is not a number or boolean is left as it is.
+func typeXMLLeaves(tree interface{}, t reflect.Type) interface{} {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return tree
+	}
+	switch tree := tree.(type) {
+	case string:
+		text := strings.TrimSpace(tree)
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			var n json.Number
+			if json.Unmarshal([]byte(text), &n) == nil {
+				return n
+			}
+		case reflect.Bool:
+			if b, err := strconv.ParseBool(text); err == nil {
+				return b
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i := range tree {
+				tree[i] = typeXMLLeaves(tree[i], t.Elem())
+			}
+		}
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Map:
+			for key, value := range tree {
+				tree[key] = typeXMLLeaves(value, t.Elem())
+			}
+		case reflect.Struct:
+			fields := map[string]reflect.Type{}
+			jsonFields(t, fields)
+			for key, value := range tree {
+				ft, ok := fields[key]
+				for name, typ := range fields {
+					if !ok && strings.EqualFold(name, key) {
+						ft, ok = typ, true // encoding/json matches field names case-insensitively too
+					}
+				}
+				if ok {
+					tree[key] = typeXMLLeaves(value, ft)
+				}
+			}
+		}
+	}
+	return tree
+}
+
+// jsonFields collects the JSON names of the fields of struct type t, the fields of embedded structs included
+func jsonFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			jsonFields(ft, fields)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+}
+
+// readXMLElement returns the text of a leaf, a list when all children are named after the element
+// (or share one name), otherwise an object
+func readXMLElement(dec *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	var text strings.Builder
+	var names []string
+	var values []interface{}
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			value, err := readXMLElement(dec, t)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			for _, attr := range t.Attr {
+				if name == "entry" && attr.Name.Local == "key" {
+					name = attr.Value
+				}
+			}
+			names = append(names, name)
+			values = append(values, value)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(names) == 0 {
+				if strings.TrimSpace(text.String()) == "" {
+					return nil, nil
+				}
+				return text.String(), nil
+			}
+			list := true
+			for _, name := range names {
+				list = list && name == names[0]
+			}
+			if list && (len(names) > 1 || names[0] == singular(start.Name.Local)) {
+				return values, nil
+			}
+			fields := map[string]interface{}{}
+			for i, name := range names {
+				fields[name] = values[i]
+			}
+			return fields, nil
+		}
+	}
+}
+
+func writeYAML(w io.Writer, v interface{}) error {
+	tree, err := toTree(v)
+	if err != nil {
+		return err
+	}
+	node, err := yamlNode(tree)
+	if err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func yamlNode(v interface{}) (*yaml.Node, error) {
+	switch v := v.(type) {
+	case *object:
+		n := &yaml.Node{Kind: yaml.MappingNode}
+		for _, key := range v.keys {
+			value, err := yamlNode(v.values[key])
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+		}
+		return n, nil
+	case []interface{}:
+		n := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range v {
+			value, err := yamlNode(item)
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, value)
+		}
+		return n, nil
+	}
+	n := &yaml.Node{}
+	return n, n.Encode(v)
+}
+
+func readYAML(data []byte, v interface{}) error {
+	var tree interface{}
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return err
+	}
+	return fromTree(tree, v)
+}
+
+// EncodeMsgpack keeps the keys of an object in order in MessagePack too
+func (o *object) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if err := enc.EncodeMapLen(len(o.keys)); err != nil {
+		return err
+	}
+	for _, key := range o.keys {
+		if err := enc.EncodeString(key); err != nil {
+			return err
+		}
+		if err := enc.Encode(o.values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeMsgpack(w io.Writer, v interface{}) error {
+	tree, err := toTree(v)
+	if err != nil {
+		return err
+	}
+	return msgpack.NewEncoder(w).Encode(tree)
+}
+
+func readMsgpack(data []byte, v interface{}) error {
+	var tree interface{}
+	if err := msgpack.Unmarshal(data, &tree); err != nil {
+		return err
+	}
+	return fromTree(tree, v)
+}
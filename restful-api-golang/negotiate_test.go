@@ -0,0 +1,59 @@
+// negotiate_test.go
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestReadXMLTypesLeaves(t *testing.T) {
+	type counts struct {
+		Views int `json:"views"`
+	}
+	type doc struct {
+		counts
+		Name   string         `json:"name"`
+		Score  float64        `json:"score"`
+		Done   bool           `json:"done"`
+		Sizes  []int          `json:"sizes"`
+		Nested *counts        `json:"nested"`
+		ByKey  map[string]int `json:"byKey"`
+	}
+	in := doc{counts: counts{Views: 3}, Name: "42", Score: 1.5, Done: true, Sizes: []int{1, 2}, Nested: &counts{Views: 7}, ByKey: map[string]int{"a": 1}}
+	var buf bytes.Buffer
+	if err := writeXML(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+	var out doc
+	if err := readXML(buf.Bytes(), &out); err != nil {
+		t.Fatalf("reading %s: %v", buf.String(), err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("got %+v back from %s, want %+v", out, buf.String(), in)
+	}
+
+	for _, bad := range []string{
+		`<doc><score>high</score></doc>`,
+		`<doc><done>maybe</done></doc>`,
+		`<doc><views>3.5</views></doc>`,
+		`<doc><sizes><size>1</size><size>x</size></sizes></doc>`,
+	} {
+		if err := readXML([]byte(bad), &out); err == nil {
+			t.Errorf("read %s without an error", bad)
+		}
+	}
+}
+
+func TestXMLBodyWithWrongTypeIsRejected(t *testing.T) {
+	h := newRouter(newTestServer(t, newMemoryStore()))
+	body := `<article><title>Post</title><wordCount>many</wordCount></article>`
+	if w := do(h, "POST", "/article", body, "Content-Type", "application/xml"); w.Code != http.StatusBadRequest {
+		t.Errorf("XML body with text in a number field: got %d, want 400", w.Code)
+	}
+	body = `<article><title>Post</title><wordCount>5</wordCount></article>`
+	if w := do(h, "POST", "/article", body, "Content-Type", "application/xml"); w.Code != http.StatusOK {
+		t.Errorf("XML body with a number in a number field: got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,262 @@
+// ot.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// textOp - an edit of a whole text, operational transform style (the format of ot.js):
+// a list of components that walk over the text from start to end.
+// In JSON a positive number retains that many characters, a string inserts it
+// and a negative number deletes that many characters: [5, "abc", -2, 3].
+// Lengths and positions count runes, not bytes.
+type textOp []opComponent
+
+// opComponent - exactly one of the fields is set
+type opComponent struct {
+	retain int
+	insert string
+	delete int
+}
+
+var errOpLength = errors.New("the operation does not span the whole text")
+
+func (c opComponent) MarshalJSON() ([]byte, error) {
+	switch {
+	case c.insert != "":
+		return json.Marshal(c.insert)
+	case c.delete > 0:
+		return json.Marshal(-c.delete)
+	}
+	return json.Marshal(c.retain)
+}
+
+func (c *opComponent) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return errors.New("an insert must not be empty")
+		}
+		*c = opComponent{insert: v}
+		return nil
+	case float64:
+		n := int(v)
+		switch {
+		case float64(n) != v || n == 0:
+			return fmt.Errorf("%v is no retain or delete count", v)
+		case n > 0:
+			*c = opComponent{retain: n}
+		default:
+			*c = opComponent{delete: -n}
+		}
+		return nil
+	}
+	return errors.New("operation components are numbers or strings")
+}
+
+// the builders merge with the last component, so an operation stays as short as possible
+
+func (op *textOp) retain(n int) {
+	if n <= 0 {
+		return
+	}
+	if last := len(*op) - 1; last >= 0 && (*op)[last].retain > 0 {
+		(*op)[last].retain += n
+		return
+	}
+	*op = append(*op, opComponent{retain: n})
+}
+
+// insert keeps an insert in front of a delete next to it, the two orders mean the same
+func (op *textOp) insert(s string) {
+	if s == "" {
+		return
+	}
+	ops := *op
+	last := len(ops) - 1
+	switch {
+	case last >= 0 && ops[last].insert != "":
+		ops[last].insert += s
+	case last >= 0 && ops[last].delete > 0:
+		if last > 0 && ops[last-1].insert != "" {
+			ops[last-1].insert += s
+			break
+		}
+		ops = append(ops[:last], opComponent{insert: s}, ops[last])
+	default:
+		ops = append(ops, opComponent{insert: s})
+	}
+	*op = ops
+}
+
+func (op *textOp) delete(n int) {
+	if n <= 0 {
+		return
+	}
+	if last := len(*op) - 1; last >= 0 && (*op)[last].delete > 0 {
+		(*op)[last].delete += n
+		return
+	}
+	*op = append(*op, opComponent{delete: n})
+}
+
+// baseLength - the length of the text the operation applies to
+func (op textOp) baseLength() int {
+	n := 0
+	for _, c := range op {
+		n += c.retain + c.delete
+	}
+	return n
+}
+
+// apply runs the operation over text
+func (op textOp) apply(text string) (string, error) {
+	runes := []rune(text)
+	if op.baseLength() != len(runes) {
+		return "", fmt.Errorf("%w: it covers %d characters, the text has %d", errOpLength, op.baseLength(), len(runes))
+	}
+	out := make([]rune, 0, len(runes))
+	pos := 0
+	for _, c := range op {
+		switch {
+		case c.retain > 0:
+			out = append(out, runes[pos:pos+c.retain]...)
+			pos += c.retain
+		case c.insert != "":
+			out = append(out, []rune(c.insert)...)
+		default:
+			pos += c.delete
+		}
+	}
+	return string(out), nil
+}
+
+// transform takes two operations made on the same text at the same time and returns a' and b',
+// so that a then b' and b then a' end up with the same text.
+// When both insert at the same place the insert of a goes first.
+func transform(a, b textOp) (textOp, textOp, error) {
+	if a.baseLength() != b.baseLength() {
+		return nil, nil, fmt.Errorf("%w: the operations apply to texts of %d and %d characters", errOpLength, a.baseLength(), b.baseLength())
+	}
+	var a2, b2 textOp
+	i, j := 0, 0
+	var ca, cb opComponent // the parts of a[i] and b[j] not used up yet
+	if len(a) > 0 {
+		ca = a[0]
+	}
+	if len(b) > 0 {
+		cb = b[0]
+	}
+	nextA := func() {
+		if i++; i < len(a) {
+			ca = a[i]
+		}
+	}
+	nextB := func() {
+		if j++; j < len(b) {
+			cb = b[j]
+		}
+	}
+
+	for i < len(a) || j < len(b) {
+		if i < len(a) && ca.insert != "" {
+			a2.insert(ca.insert)
+			b2.retain(utf8.RuneCountInString(ca.insert))
+			nextA()
+			continue
+		}
+		if j < len(b) && cb.insert != "" {
+			a2.retain(utf8.RuneCountInString(cb.insert))
+			b2.insert(cb.insert)
+			nextB()
+			continue
+		}
+		if i >= len(a) || j >= len(b) {
+			return nil, nil, errOpLength // cannot happen with equal base lengths
+		}
+
+		n := ca.retain + ca.delete
+		if m := cb.retain + cb.delete; m < n {
+			n = m
+		}
+		switch {
+		case ca.retain > 0 && cb.retain > 0:
+			a2.retain(n)
+			b2.retain(n)
+		case ca.delete > 0 && cb.retain > 0:
+			a2.delete(n)
+		case ca.retain > 0 && cb.delete > 0:
+			b2.delete(n)
+		}
+		// both deleting the same characters leaves nothing for either to do
+
+		if ca.retain > 0 {
+			ca.retain -= n
+		} else {
+			ca.delete -= n
+		}
+		if cb.retain > 0 {
+			cb.retain -= n
+		} else {
+			cb.delete -= n
+		}
+		if ca.retain == 0 && ca.delete == 0 {
+			nextA()
+		}
+		if cb.retain == 0 && cb.delete == 0 {
+			nextB()
+		}
+	}
+	return a2, b2, nil
+}
+
+// transformIndex moves a position in the text (a cursor) over an operation,
+// text inserted right at the position pushes it along
+func transformIndex(pos int, op textOp) int {
+	index, moved := 0, pos
+	for _, c := range op {
+		if index > pos {
+			break
+		}
+		switch {
+		case c.retain > 0:
+			index += c.retain
+		case c.insert != "":
+			moved += utf8.RuneCountInString(c.insert)
+		default:
+			if gone := pos - index; gone < c.delete {
+				moved -= gone
+			} else {
+				moved -= c.delete
+			}
+			index += c.delete
+		}
+	}
+	return moved
+}
+
+// diffOp returns an operation turning from into to: the common start and end are kept, the middle is replaced
+func diffOp(from, to string) textOp {
+	a, b := []rune(from), []rune(to)
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var op textOp
+	op.retain(prefix)
+	op.insert(string(b[prefix : len(b)-suffix]))
+	op.delete(len(a) - prefix - suffix)
+	op.retain(suffix)
+	return op
+}
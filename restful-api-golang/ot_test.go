@@ -0,0 +1,148 @@
+// ot_test.go
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+)
+
+// randomOp makes an operation over a text of n runes, inserts use non-ASCII runes to check rune counting
+func randomOp(rng *rand.Rand, n int) textOp {
+	letters := []rune("abcé日")
+	var op textOp
+	for n > 0 {
+		switch k := 1 + rng.Intn(n); rng.Intn(3) {
+		case 0:
+			op.retain(k)
+			n -= k
+		case 1:
+			op.delete(k)
+			n -= k
+		default:
+			insert := make([]rune, 1+rng.Intn(3))
+			for i := range insert {
+				insert[i] = letters[rng.Intn(len(letters))]
+			}
+			op.insert(string(insert))
+		}
+	}
+	if rng.Intn(2) == 0 {
+		op.insert("end")
+	}
+	return op
+}
+
+func TestApply(t *testing.T) {
+	var op textOp
+	op.retain(2)
+	op.insert("XY")
+	op.delete(2)
+	op.retain(1)
+	got, err := op.apply("héllo")
+	if err != nil || got != "héXYo" {
+		t.Errorf("got %q (%v), want héXYo", got, err)
+	}
+	if _, err := op.apply("hello!"); err == nil {
+		t.Error("an operation over 5 characters applied to 6")
+	}
+}
+
+func TestTransformConverges(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for round := 0; round < 2000; round++ {
+		text := []rune("héllo wörld")[:rng.Intn(8)]
+		a, b := randomOp(rng, len(text)), randomOp(rng, len(text))
+		a2, b2, err := transform(a, b)
+		if err != nil {
+			t.Fatalf("transform(%v, %v): %v", a, b, err)
+		}
+		ab, err := a.apply(string(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ab, err = b2.apply(ab); err != nil {
+			t.Fatalf("b' %v does not apply after a %v: %v", b2, a, err)
+		}
+		ba, err := b.apply(string(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ba, err = a2.apply(ba); err != nil {
+			t.Fatalf("a' %v does not apply after b %v: %v", a2, b, err)
+		}
+		if ab != ba {
+			t.Fatalf("text %q, a %v, b %v: a then b' gives %q, b then a' gives %q", string(text), a, b, ab, ba)
+		}
+	}
+
+	var a, b textOp
+	a.retain(3)
+	b.retain(4)
+	if _, _, err := transform(a, b); err == nil {
+		t.Error("transformed operations over texts of different lengths")
+	}
+}
+
+func TestTransformInsertsAtSamePlace(t *testing.T) {
+	var a, b textOp
+	a.retain(1)
+	a.insert("A")
+	a.retain(1)
+	b.retain(1)
+	b.insert("B")
+	b.retain(1)
+	a2, b2, _ := transform(a, b)
+	ab, _ := a.apply("xy")
+	ab, _ = b2.apply(ab)
+	ba, _ := b.apply("xy")
+	ba, _ = a2.apply(ba)
+	if ab != "xABy" || ba != "xABy" {
+		t.Errorf("got %q and %q, want the insert of a first: xABy", ab, ba)
+	}
+}
+
+func TestTransformIndex(t *testing.T) {
+	op := func(components ...interface{}) textOp {
+		var op textOp
+		data, _ := json.Marshal(components)
+		if err := json.Unmarshal(data, &op); err != nil {
+			t.Fatal(err)
+		}
+		return op
+	}
+	for _, c := range []struct {
+		pos  int
+		op   textOp
+		want int
+	}{
+		{3, op(10), 3},
+		{3, op(1, "ab", 9), 5},  // insert before
+		{3, op(3, "ab", 7), 5},  // insert right at the cursor pushes it
+		{3, op(4, "ab", 6), 3},  // insert after
+		{3, op(-2, 8), 1},       // delete before
+		{3, op(1, -5, 4), 1},    // delete around the cursor
+		{3, op(3, -2, 5), 3},    // delete after
+		{3, op("日本", -1, 9), 4}, // positions count runes
+	} {
+		if got := transformIndex(c.pos, c.op); got != c.want {
+			t.Errorf("transformIndex(%d, %v) = %d, want %d", c.pos, c.op, got, c.want)
+		}
+	}
+}
+
+func TestDiffOp(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	texts := []string{"", "a", "hello", "help", "héllo", "jello", "hello world", "日本語"}
+	for round := 0; round < 200; round++ {
+		from, to := texts[rng.Intn(len(texts))], texts[rng.Intn(len(texts))]
+		op := diffOp(from, to)
+		if got, err := op.apply(from); err != nil || got != to {
+			t.Errorf("diffOp(%q, %q) = %v gives %q (%v)", from, to, op, got, err)
+		}
+	}
+	data, _ := json.Marshal(diffOp("hello world", "hello, world"))
+	if string(data) != `[5,",",6]` {
+		t.Errorf("diffOp keeps more than the common start and end: %s", data)
+	}
+}
@@ -0,0 +1,111 @@
+// patch.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/gorilla/mux"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json" // RFC 7396
+	jsonPatchType  = "application/json-patch+json"  // RFC 6902
+)
+
+// patchError - a patch that cannot be applied, with the status to answer
+type patchError struct {
+	status int
+	err    error
+}
+
+func (e *patchError) Error() string { return e.err.Error() }
+
+// applyPatch returns the article with the patch applied.
+// The patch works on the article as stored, so authorId is patchable and the
+// embedded author object only shows up if the patch adds one.
+func applyPatch(contentType string, article Article, body []byte) (Article, error) {
+	article.Author = nil
+	doc, err := json.Marshal(article)
+	if err != nil {
+		return Article{}, err
+	}
+
+	var patched []byte
+	if contentType == mergePatchType {
+		patched, err = jsonpatch.MergePatch(doc, body)
+	} else {
+		var patch jsonpatch.Patch
+		patch, err = jsonpatch.DecodePatch(body)
+		if err == nil {
+			patched, err = patch.Apply(doc)
+		}
+	}
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		return Article{}, &patchError{http.StatusConflict, err}
+	}
+	if err != nil {
+		return Article{}, &patchError{http.StatusBadRequest, err}
+	}
+
+	var updated Article
+	if err := json.Unmarshal(patched, &updated); err != nil {
+		return Article{}, &patchError{http.StatusUnprocessableEntity, err}
+	}
+	return updated, nil
+}
+
+// patchArticle applies a JSON Merge Patch or a JSON Patch to the stored article
+func (s *server) patchArticle(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != mergePatchType && contentType != jsonPatchType {
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		http.Error(w, "Content-Type must be "+mergePatchType+" or "+jsonPatchType, http.StatusUnsupportedMediaType)
+		return
+	}
+	reqBody, _ := ioutil.ReadAll(r.Body)
+
+	updated, err := s.store.UpdateIf(id, func(current Article) (Article, error) {
+		if err := s.checkIfMatch(r, current); err != nil {
+			return Article{}, err
+		}
+		updated, err := applyPatch(contentType, current, reqBody)
+		if err != nil {
+			return Article{}, err
+		}
+		normalizeTaxonomy(&updated)
+		if err := normalizeLocales(&updated); err != nil {
+			return Article{}, err
+		}
+		if err := applyWorkflow(&current, &updated, time.Now().UTC()); err != nil {
+			return Article{}, err
+		}
+		if err := s.resolveAuthor(&updated); err != nil {
+			return Article{}, &patchError{http.StatusBadRequest, err}
+		}
+		updated.Slug = s.slugs.claim(id, updated.Title)
+		stampEdit(r, &updated, current.CreatedAt)
+		// the id in the path wins, a patch cannot move an article
+		return updated, nil
+	})
+	if err != nil {
+		s.slugs.release(id) // the write failed after the claim, e.g. an unknown author
+	}
+	if pe, ok := err.(*patchError); ok {
+		http.Error(w, pe.Error(), pe.status)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", articleETag(updated))
+	render(w, r, s.present(updated))
+}
@@ -0,0 +1,237 @@
+// related.go
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultRelated = 5
+
+	relatedTitleWeight = 2    // a title word counts like two words of the content
+	relatedAuthorBoost = 0.1  // added to the similarity when both articles have the same author
+	relatedTagBoost    = 0.05 // added per tag the two articles share
+)
+
+// words too common to say anything about what an article is about
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "has": true, "in": true, "is": true, "it": true, "its": true, "of": true,
+	"on": true, "or": true, "that": true, "the": true, "this": true, "to": true, "was": true, "with": true,
+}
+
+// relatedDoc - what the related index keeps of one article: its term counts, for the TF-IDF vector, and the article itself
+type relatedDoc struct {
+	article Article
+	tf      map[string]float64
+}
+
+// relatedIndex keeps term counts and document frequencies over title and content.
+// It is a storeListener, so a write only recounts the article written;
+// the TF-IDF vectors are built from the counts when they are compared, with the idf of that moment.
+type relatedIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]*relatedDoc
+	df       map[string]int             // term -> number of articles using it
+	postings map[string]map[string]bool // term -> ids of the articles using it
+}
+
+func newRelatedIndex() *relatedIndex {
+	return &relatedIndex{
+		docs:     make(map[string]*relatedDoc),
+		df:       make(map[string]int),
+		postings: make(map[string]map[string]bool),
+	}
+}
+
+func termCounts(article Article) map[string]float64 {
+	tf := map[string]float64{}
+	add := func(text string, weight float64) {
+		for _, tok := range tokenize(text) {
+			if len(tok.term) > 1 && !stopWords[tok.term] {
+				tf[tok.term] += weight
+			}
+		}
+	}
+	add(article.Title, relatedTitleWeight)
+	add(article.Content, 1)
+	return tf
+}
+
+// remove must be called with ix.mu held
+func (ix *relatedIndex) remove(id string) {
+	doc, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	for term := range doc.tf {
+		if ix.df[term]--; ix.df[term] <= 0 {
+			delete(ix.df, term)
+			delete(ix.postings, term)
+			continue
+		}
+		delete(ix.postings[term], id)
+	}
+	delete(ix.docs, id)
+}
+
+func (ix *relatedIndex) articleSaved(article Article) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(article.Id)
+	doc := &relatedDoc{article: article.clone(), tf: termCounts(article)}
+	for term := range doc.tf {
+		ix.df[term]++
+		if ix.postings[term] == nil {
+			ix.postings[term] = map[string]bool{}
+		}
+		ix.postings[term][article.Id] = true
+	}
+	ix.docs[article.Id] = doc
+}
+
+func (ix *relatedIndex) articleDeleted(id string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+}
+
+// vector - the TF-IDF weights of a document and their length, must be called with ix.mu held
+func (ix *relatedIndex) vector(doc *relatedDoc) (map[string]float64, float64) {
+	n := float64(len(ix.docs))
+	weights := make(map[string]float64, len(doc.tf))
+	norm := 0.0
+	for term, count := range doc.tf {
+		w := (1 + math.Log(count)) * math.Log(1+n/float64(ix.df[term]))
+		weights[term] = w
+		norm += w * w
+	}
+	return weights, math.Sqrt(norm)
+}
+
+// relatedArticle - one entry of GET /article/{id}/related
+type relatedArticle struct {
+	Article Article `json:"article"`
+	Score   float64 `json:"score"`
+}
+
+// related returns up to limit articles most like the article id among those keep accepts, best first
+func (ix *relatedIndex) related(id string, limit int, keep func(a Article) bool) ([]relatedArticle, error) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	target, ok := ix.docs[id]
+	if !ok {
+		return nil, ErrArticleNotFound
+	}
+	weights, norm := ix.vector(target)
+
+	// candidates share a word, the author or a tag with the target
+	candidates := map[string]bool{}
+	for term := range target.tf {
+		for other := range ix.postings[term] {
+			candidates[other] = true
+		}
+	}
+	for other, doc := range ix.docs {
+		if target.article.AuthorId != "" && doc.article.AuthorId == target.article.AuthorId {
+			candidates[other] = true
+		}
+		if sharedTags(target.article, doc.article) > 0 {
+			candidates[other] = true
+		}
+	}
+	delete(candidates, id)
+
+	results := []relatedArticle{}
+	for other := range candidates {
+		doc := ix.docs[other]
+		if keep != nil && !keep(doc.article) {
+			continue
+		}
+		score := 0.0
+		if otherWeights, otherNorm := ix.vector(doc); norm > 0 && otherNorm > 0 {
+			dot := 0.0
+			for term, w := range weights {
+				dot += w * otherWeights[term]
+			}
+			score = dot / (norm * otherNorm)
+		}
+		if target.article.AuthorId != "" && doc.article.AuthorId == target.article.AuthorId {
+			score += relatedAuthorBoost
+		}
+		score += relatedTagBoost * float64(sharedTags(target.article, doc.article))
+		if score > 0 {
+			results = append(results, relatedArticle{Article: doc.article.clone(), Score: score})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Article.Id < results[j].Article.Id
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func sharedTags(a, b Article) int {
+	shared := 0
+	for _, tag := range a.Tags {
+		for _, other := range b.Tags {
+			if tag == other {
+				shared++
+				break
+			}
+		}
+	}
+	return shared
+}
+
+// GET /article/{id}/related?limit=5 - "see also" for an article
+func (s *server) returnRelatedArticles(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: returnRelatedArticles")
+	id := mux.Vars(r)["id"]
+
+	limit := defaultRelated
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		if n > maxPageSize {
+			n = maxPageSize
+		}
+		limit = n
+	}
+
+	if _, err := s.visibleArticle(r, id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var keep func(a Article) bool
+	if !wantsUnpublished(r) {
+		keep = isPublished
+	}
+	results, err := s.related.related(id, limit, keep)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	for i := range results {
+		results[i].Article = s.present(results[i].Article)
+	}
+	render(w, r, results)
+}
@@ -0,0 +1,523 @@
+// revisions.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// Revision - an immutable copy of an article as it was saved, numbered from 1 per article
+type Revision struct {
+	Number    int       `json:"number"`
+	Editor    string    `json:"editor"`
+	Timestamp time.Time `json:"timestamp"`
+	Article   Article   `json:"article"`
+}
+
+// revisionStore records a revision every time an article is saved. It is a storeListener,
+// so revisions come in the same order as the writes. In persistent mode the revisions
+// are appended to an NDJSON log, they never change once written; only purging an article
+// rewrites the log without its history.
+type revisionStore struct {
+	mu        sync.RWMutex
+	revisions map[string][]Revision
+	log       *os.File
+	path      string
+}
+
+// newRevisionStore loads the revision log at path, an empty path keeps revisions in memory only
+func newRevisionStore(path string) (*revisionStore, error) {
+	s := &revisionStore{revisions: map[string][]Revision{}}
+	if path == "" {
+		return s, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(f)
+	var good int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("revisions: dropping torn entry at offset %d", good)
+				if err := f.Truncate(good); err != nil {
+					return nil, err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var rev Revision
+		if err := json.Unmarshal(line, &rev); err != nil {
+			log.Printf("revisions: dropping corrupt log from offset %d: %v", good, err)
+			if err := f.Truncate(good); err != nil {
+				return nil, err
+			}
+			break
+		}
+		good += int64(len(line))
+		s.revisions[rev.Article.Id] = append(s.revisions[rev.Article.Id], rev)
+	}
+	s.log, s.path = f, path
+	return s, nil
+}
+
+func (s *revisionStore) articleSaved(article Article) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	article.Author = nil
+	history := s.revisions[article.Id]
+	if n := len(history); n > 0 && sameArticle(history[n-1].Article, article) {
+		return // nothing changed, e.g. the store being replayed at startup
+	}
+
+	editor := article.UpdatedBy
+	if editor == "" {
+		editor = "system" // seeded, migrated or imported without an editor
+	}
+	rev := Revision{
+		Number:    len(history) + 1,
+		Editor:    editor,
+		Timestamp: article.UpdatedAt,
+		Article:   article,
+	}
+	if s.log != nil {
+		line, err := json.Marshal(rev)
+		if err == nil {
+			_, err = s.log.Write(append(line, '\n'))
+		}
+		if err == nil {
+			err = s.log.Sync()
+		}
+		if err != nil {
+			log.Printf("revisions: unable to record revision %d of article %s: %v", rev.Number, article.Id, err)
+		}
+	}
+	s.revisions[article.Id] = append(history, rev)
+}
+
+// the history stays around after a delete, so a restored article keeps it, see forget
+func (s *revisionStore) articleDeleted(id string) {}
+
+// forget drops the history of a purged article, in persistent mode the log is rewritten without it
+func (s *revisionStore) forget(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.revisions[id]; !ok {
+		return nil
+	}
+	if s.log != nil {
+		ids := make([]string, 0, len(s.revisions))
+		for other := range s.revisions {
+			if other != id {
+				ids = append(ids, other)
+			}
+		}
+		sort.Strings(ids)
+		var buf bytes.Buffer
+		for _, other := range ids {
+			for _, rev := range s.revisions[other] {
+				line, err := json.Marshal(rev)
+				if err != nil {
+					return err
+				}
+				buf.Write(append(line, '\n'))
+			}
+		}
+		if err := writeFileAtomic(s.path, buf.Bytes()); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		s.log.Close()
+		s.log = f
+	}
+	delete(s.revisions, id)
+	return nil
+}
+
+func sameArticle(a, b Article) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+func (s *revisionStore) List(id string) []Revision {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Revision{}, s.revisions[id]...)
+}
+
+func (s *revisionStore) Get(id string, n int) (Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := s.revisions[id]
+	if n < 1 || n > len(history) {
+		return Revision{}, ErrRevisionNotFound
+	}
+	rev := history[n-1]
+	rev.Article = rev.Article.clone()
+	return rev, nil
+}
+
+// editorOf - who is making the change, taken from the X-Editor header
+func editorOf(r *http.Request, article Article) string {
+	if editor := strings.TrimSpace(r.Header.Get("X-Editor")); editor != "" {
+		return editor
+	}
+	if article.AuthorId != "" {
+		return article.AuthorId
+	}
+	return "anonymous"
+}
+
+// stampEdit sets the bookkeeping fields of an article that is about to be written,
+// createdAt comes from the stored version (zero for a new article)
+func stampEdit(r *http.Request, article *Article, createdAt time.Time) {
+	now := time.Now().UTC()
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+	article.CreatedAt = createdAt
+	article.UpdatedAt = now
+	article.UpdatedBy = editorOf(r, *article)
+}
+
+// revisionNumber reads a revision number from the path
+func revisionNumber(w http.ResponseWriter, v string) (int, bool) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		http.Error(w, "revision must be a positive number", http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
+
+func (s *server) returnArticleRevisions(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	// the history of an article in the trash stays readable with ?preview=true
+	if _, err := s.visibleArticle(r, id); err != nil && !wantsUnpublished(r) {
+		writeStoreError(w, err)
+		return
+	}
+	revisions := s.revisions.List(id)
+	if len(revisions) == 0 {
+		writeStoreError(w, ErrArticleNotFound)
+		return
+	}
+	render(w, r, revisions)
+}
+
+func (s *server) returnArticleRevision(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if _, err := s.visibleArticle(r, vars["id"]); err != nil && !wantsUnpublished(r) {
+		writeStoreError(w, err)
+		return
+	}
+	n, ok := revisionNumber(w, vars["n"])
+	if !ok {
+		return
+	}
+	rev, err := s.revisions.Get(vars["id"], n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	render(w, r, rev)
+}
+
+// GET /article/{id}/diff?from=1&to=2 - unified diff between two revisions, to defaults to the latest
+func (s *server) diffArticleRevisions(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := s.visibleArticle(r, id); err != nil && !wantsUnpublished(r) {
+		writeStoreError(w, err)
+		return
+	}
+	revisions := s.revisions.List(id)
+	if len(revisions) == 0 {
+		writeStoreError(w, ErrArticleNotFound)
+		return
+	}
+
+	from, to := 1, len(revisions)
+	if v := r.URL.Query().Get("from"); v != "" {
+		n, ok := revisionNumber(w, v)
+		if !ok {
+			return
+		}
+		from = n
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		n, ok := revisionNumber(w, v)
+		if !ok {
+			return
+		}
+		to = n
+	}
+	a, err := s.revisions.Get(id, from)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	b, err := s.revisions.Get(id, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	fmt.Fprint(w, unifiedDiff(
+		fmt.Sprintf("article/%s@%d", id, from), fmt.Sprintf("article/%s@%d", id, to),
+		revisionLines(a), revisionLines(b),
+	))
+}
+
+// POST /article/{id}/revert/{n} - saves revision n again as the newest version
+func (s *server) revertArticle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	n, ok := revisionNumber(w, vars["n"])
+	if !ok {
+		return
+	}
+
+	rev, err := s.revisions.Get(id, n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	article, err := s.store.UpdateIf(id, func(current Article) (Article, error) {
+		if err := s.checkIfMatch(r, current); err != nil {
+			return Article{}, err
+		}
+		article := rev.Article
+		// a revert brings back the content, where the article is in the workflow stays as it is
+		article.Status, article.PublishAt = current.Status, current.PublishAt
+		article.Slug = s.slugs.claim(id, article.Title)
+		stampEdit(r, &article, current.CreatedAt)
+		return article, nil
+	})
+	if err != nil {
+		s.slugs.release(id) // the write failed after the claim, e.g. the author is gone
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", articleETag(article))
+	render(w, r, s.present(article))
+}
+
+// revisionLines is the text form of a revision the diff works on
+func revisionLines(rev Revision) []string {
+	a := rev.Article
+	lines := []string{
+		"Title: " + a.Title,
+		"Desc: " + a.Desc,
+		"Author: " + a.AuthorId,
+		"Status: " + statusOf(a),
+		"Category: " + a.Category,
+		"Tags: " + strings.Join(a.Tags, ", "),
+		"",
+	}
+	return append(lines, strings.Split(a.Content, "\n")...)
+}
+
+// number of unchanged lines shown around every change
+const diffContext = 3
+
+// the longest common subsequence of diffLines is only looked for up to this many line comparisons,
+// a longer change is shown as all its old lines removed and all its new lines added
+const maxDiffCells = 4 << 20
+
+// diffLine - one line of a diff
+type diffLine struct {
+	op   byte // ' ', '-' or '+'
+	text string
+	i, j int // line index in a and b before this line
+}
+
+// diffLines lines a and b up: the common start and end are kept as they are,
+// the lines in between are compared with a longest common subsequence
+func diffLines(a, b []string) []diffLine {
+	var lines []diffLine
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		lines = append(lines, diffLine{' ', a[prefix], prefix, prefix})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(ma)*len(mb) > maxDiffCells {
+		lines = appendReplaced(lines, ma, mb, prefix, prefix)
+	} else {
+		lines = appendLCS(lines, ma, mb, prefix, prefix)
+	}
+
+	for k := suffix; k > 0; k-- {
+		lines = append(lines, diffLine{' ', a[len(a)-k], len(a) - k, len(b) - k})
+	}
+	return lines
+}
+
+// appendReplaced adds a as removed and b as added, i and j are their indexes in the whole texts
+func appendReplaced(lines []diffLine, a, b []string, i, j int) []diffLine {
+	for k, text := range a {
+		lines = append(lines, diffLine{'-', text, i + k, j})
+	}
+	for k, text := range b {
+		lines = append(lines, diffLine{'+', text, i + len(a), j + k})
+	}
+	return lines
+}
+
+// appendLCS adds the diff of a and b along a longest common subsequence, i and j are their indexes
+// in the whole texts. It splits a in half and b where the LCS crosses that half (Hirschberg),
+// so it needs memory in the length of b rather than a table of len(a)*len(b) cells.
+func appendLCS(lines []diffLine, a, b []string, i, j int) []diffLine {
+	switch {
+	case len(a) == 0 || len(b) == 0:
+		return appendReplaced(lines, a, b, i, j)
+	case len(a) == 1:
+		for k, text := range b {
+			if text == a[0] {
+				lines = appendReplaced(lines, nil, b[:k], i, j)
+				lines = append(lines, diffLine{' ', text, i, j + k})
+				return appendReplaced(lines, nil, b[k+1:], i+1, j+k+1)
+			}
+		}
+		return appendReplaced(lines, a, b, i, j)
+	}
+
+	mid := len(a) / 2
+	head, tail := lcsLengths(a[:mid], b, false), lcsLengths(a[mid:], b, true)
+	split, best := 0, -1
+	for k := 0; k <= len(b); k++ {
+		if n := head[k] + tail[k]; n > best {
+			split, best = k, n
+		}
+	}
+	lines = appendLCS(lines, a[:mid], b[:split], i, j)
+	return appendLCS(lines, a[mid:], b[split:], i+mid, j+split)
+}
+
+// lcsLengths returns the length of the LCS of a and b[:k] for every k,
+// or with fromEnd that of a and b[k:], one row of the LCS table at a time
+func lcsLengths(a, b []string, fromEnd bool) []int {
+	at := func(s []string, k int) string {
+		if fromEnd {
+			return s[len(s)-1-k]
+		}
+		return s[k]
+	}
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for x := range a {
+		for y := range b {
+			switch {
+			case at(a, x) == at(b, y):
+				cur[y+1] = prev[y] + 1
+			case prev[y+1] >= cur[y]:
+				cur[y+1] = prev[y+1]
+			default:
+				cur[y+1] = cur[y]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	if fromEnd {
+		for x, y := 0, len(prev)-1; x < y; x, y = x+1, y-1 {
+			prev[x], prev[y] = prev[y], prev[x]
+		}
+	}
+	return prev
+}
+
+// unifiedDiff compares two lists of lines and prints the result in the unified format of diff -u
+func unifiedDiff(nameA, nameB string, a, b []string) string {
+	lines := diffLines(a, b)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+	for k := 0; k < len(lines); {
+		if lines[k].op == ' ' {
+			k++
+			continue
+		}
+		// grow the hunk until there are more than 2*diffContext unchanged lines in a row
+		start := k - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for end < len(lines) {
+			if lines[end].op != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(lines) && lines[run].op == ' ' {
+				run++
+			}
+			if run == len(lines) || run-end > 2*diffContext {
+				end += diffContext
+				if end > len(lines) {
+					end = len(lines)
+				}
+				break
+			}
+			end = run
+		}
+
+		var countA, countB int
+		for _, l := range lines[start:end] {
+			if l.op != '+' {
+				countA++
+			}
+			if l.op != '-' {
+				countB++
+			}
+		}
+		startA, startB := lines[start].i+1, lines[start].j+1
+		if countA == 0 {
+			startA--
+		}
+		if countB == 0 {
+			startB--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", startA, countA, startB, countB)
+		for _, l := range lines[start:end] {
+			fmt.Fprintf(&out, "%c%s\n", l.op, l.text)
+		}
+		k = end
+	}
+	return out.String()
+}
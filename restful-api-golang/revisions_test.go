@@ -0,0 +1,135 @@
+// revisions_test.go
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := strings.Split("one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven", "\n")
+	b := strings.Split("one\ntwo\nTHREE\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven\ntwelve", "\n")
+	want := `--- a
++++ b
+@@ -1,6 +1,6 @@
+ one
+ two
+-three
++THREE
+ four
+ five
+ six
+@@ -9,3 +9,4 @@
+ nine
+ ten
+ eleven
++twelve
+`
+	if got := unifiedDiff("a", "b", a, b); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := unifiedDiff("a", "b", a, a); got != "--- a\n+++ b\n" {
+		t.Errorf("diff of equal texts has hunks:\n%s", got)
+	}
+}
+
+// sides rebuilds both texts from a diff, the unchanged and removed lines give a, the unchanged and added ones b
+func sides(lines []diffLine) (a, b []string) {
+	for _, l := range lines {
+		if l.op != '+' {
+			a = append(a, l.text)
+		}
+		if l.op != '-' {
+			b = append(b, l.text)
+		}
+	}
+	return a, b
+}
+
+func TestDiffLinesRebuildsBothSides(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	text := func(n int) []string {
+		lines := make([]string, n)
+		for i := range lines {
+			lines[i] = fmt.Sprint(rng.Intn(5))
+		}
+		return lines
+	}
+	for round := 0; round < 200; round++ {
+		a, b := text(rng.Intn(30)), text(rng.Intn(30))
+		gotA, gotB := sides(diffLines(a, b))
+		if strings.Join(gotA, "\n") != strings.Join(a, "\n") || strings.Join(gotB, "\n") != strings.Join(b, "\n") {
+			t.Fatalf("the diff of %q and %q does not give them back", a, b)
+		}
+	}
+}
+
+// TestDiffLinesIsLongest - the unchanged lines are a longest common subsequence, and every line knows where it is
+func TestDiffLinesIsLongest(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	text := func(n int) []string {
+		lines := make([]string, n)
+		for i := range lines {
+			lines[i] = fmt.Sprint(rng.Intn(4))
+		}
+		return lines
+	}
+	for round := 0; round < 200; round++ {
+		a, b := text(rng.Intn(40)), text(rng.Intn(40))
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				switch {
+				case a[i] == b[j]:
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				case lcs[i+1][j] >= lcs[i][j+1]:
+					lcs[i][j] = lcs[i+1][j]
+				default:
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+
+		kept, i, j := 0, 0, 0
+		for _, l := range diffLines(a, b) {
+			if l.i != i || l.j != j {
+				t.Fatalf("the diff of %q and %q places %c%s at %d,%d, want %d,%d", a, b, l.op, l.text, l.i, l.j, i, j)
+			}
+			if l.op != '+' {
+				i++
+			}
+			if l.op != '-' {
+				j++
+			}
+			if l.op == ' ' {
+				kept++
+			}
+		}
+		if kept != lcs[0][0] {
+			t.Fatalf("the diff of %q and %q keeps %d lines, the longest common subsequence has %d", a, b, kept, lcs[0][0])
+		}
+	}
+}
+
+// TestDiffLinesLargeInput - revisions far beyond the LCS limit still diff, in bounded memory
+func TestDiffLinesLargeInput(t *testing.T) {
+	a, b := make([]string, 20000), make([]string, 20000)
+	for i := range a {
+		a[i] = fmt.Sprintf("old %d", i)
+		b[i] = fmt.Sprintf("new %d", i)
+	}
+	a[0], b[0] = "same start", "same start"
+	lines := diffLines(a, b)
+	gotA, gotB := sides(lines)
+	if len(gotA) != len(a) || len(gotB) != len(b) || gotA[len(a)-1] != a[len(a)-1] || gotB[len(b)-1] != b[len(b)-1] {
+		t.Fatal("the diff of two large texts does not give them back")
+	}
+	if lines[0].op != ' ' {
+		t.Errorf("the common first line is shown as changed")
+	}
+}
@@ -0,0 +1,419 @@
+// search.go
+package main
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// the fields of an article we index, a query can target one of them with a "title:" style prefix
+const (
+	fieldTitle = iota
+	fieldDesc
+	fieldContent
+	numFields
+)
+
+var (
+	fieldNames   = [numFields]string{"title", "desc", "content"}
+	fieldWeights = [numFields]float64{3, 1.5, 1} // a hit in the title counts more than one in the content
+)
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// token - a lower cased word and where it sits in the original text
+type token struct {
+	term       string
+	start, end int // byte offsets
+}
+
+// tokenize splits text into words made of letters and digits
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			tokens = append(tokens, token{term: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{term: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+type indexedField struct {
+	text   string
+	tokens []token
+}
+
+type indexedDoc struct {
+	article Article
+	fields  [numFields]indexedField
+	length  float64 // weighted number of tokens
+}
+
+// posting - the token positions of one term inside one article, per field
+type posting [numFields][]int
+
+// searchIndex - in-process inverted index over title, desc and content.
+// It is a storeListener so it follows every write to the store.
+type searchIndex struct {
+	mu          sync.RWMutex
+	docs        map[string]*indexedDoc
+	postings    map[string]map[string]*posting // term -> article id -> positions
+	totalLength float64
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		docs:     map[string]*indexedDoc{},
+		postings: map[string]map[string]*posting{},
+	}
+}
+
+func (ix *searchIndex) articleSaved(article Article) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(article.Id)
+
+	doc := &indexedDoc{article: article}
+	for f, text := range [numFields]string{article.Title, article.Desc, article.Content} {
+		doc.fields[f] = indexedField{text: text, tokens: tokenize(text)}
+		doc.length += fieldWeights[f] * float64(len(doc.fields[f].tokens))
+		for pos, tok := range doc.fields[f].tokens {
+			docs := ix.postings[tok.term]
+			if docs == nil {
+				docs = map[string]*posting{}
+				ix.postings[tok.term] = docs
+			}
+			p := docs[article.Id]
+			if p == nil {
+				p = &posting{}
+				docs[article.Id] = p
+			}
+			p[f] = append(p[f], pos)
+		}
+	}
+	ix.docs[article.Id] = doc
+	ix.totalLength += doc.length
+}
+
+func (ix *searchIndex) articleDeleted(id string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+}
+
+// remove must be called with ix.mu held
+func (ix *searchIndex) remove(id string) {
+	doc, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	for f := range doc.fields {
+		for _, tok := range doc.fields[f].tokens {
+			if docs, ok := ix.postings[tok.term]; ok {
+				delete(docs, id)
+				if len(docs) == 0 {
+					delete(ix.postings, tok.term)
+				}
+			}
+		}
+	}
+	delete(ix.docs, id)
+	ix.totalLength -= doc.length
+}
+
+// queryClause - a single word or a quoted phrase, optionally limited to one field
+type queryClause struct {
+	field int // -1 means any field
+	terms []string
+}
+
+// parseQuery understands plain words, "quoted phrases" and field prefixes such as
+// title:hello or content:"hello world". Every clause has to match.
+func parseQuery(q string) []queryClause {
+	var clauses []queryClause
+	for q = strings.TrimSpace(q); q != ""; q = strings.TrimSpace(q) {
+		field := -1
+		if colon := strings.IndexByte(q, ':'); colon > 0 && !strings.ContainsAny(q[:colon], " \t\"") {
+			for f, name := range fieldNames {
+				if strings.EqualFold(q[:colon], name) {
+					field = f
+					q = q[colon+1:]
+					break
+				}
+			}
+		}
+
+		var text string
+		if strings.HasPrefix(q, `"`) {
+			end := strings.IndexByte(q[1:], '"')
+			if end < 0 {
+				text, q = q[1:], ""
+			} else {
+				text, q = q[1:end+1], q[end+2:]
+			}
+			if terms := termsOf(text); len(terms) > 0 {
+				clauses = append(clauses, queryClause{field: field, terms: terms})
+			}
+			continue
+		}
+
+		end := strings.IndexFunc(q, unicode.IsSpace)
+		if end < 0 {
+			end = len(q)
+		}
+		text, q = q[:end], q[end:]
+		// a bare word like "hello-world" still has to match as the phrase it tokenizes to
+		if terms := termsOf(text); len(terms) > 0 {
+			clauses = append(clauses, queryClause{field: field, terms: terms})
+		}
+	}
+	return clauses
+}
+
+func termsOf(text string) []string {
+	var terms []string
+	for _, tok := range tokenize(text) {
+		terms = append(terms, tok.term)
+	}
+	return terms
+}
+
+// fieldsOf lists the fields a clause may match in
+func (c queryClause) fieldsOf() []int {
+	if c.field >= 0 {
+		return []int{c.field}
+	}
+	return []int{fieldTitle, fieldDesc, fieldContent}
+}
+
+// searchResult - one ranked hit with highlighted snippets per matching field
+type searchResult struct {
+	Article    Article           `json:"article"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+// search returns the best limit matches for q among the articles keep accepts, keep may be nil
+func (ix *searchIndex) search(q string, limit int, keep func(a Article) bool) []searchResult {
+	clauses := parseQuery(q)
+	if len(clauses) == 0 {
+		return []searchResult{}
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	type hit struct {
+		score float64
+		marks [numFields]map[int]bool // token positions to highlight
+	}
+	var hits map[string]*hit
+	for _, clause := range clauses {
+		matched := ix.matchClause(clause)
+		next := map[string]*hit{}
+		for id, positions := range matched {
+			h := &hit{}
+			if hits != nil {
+				prev, ok := hits[id]
+				if !ok {
+					continue
+				}
+				h = prev
+			}
+			h.score += ix.score(id, clause)
+			for f, marks := range positions {
+				for _, pos := range marks {
+					if h.marks[f] == nil {
+						h.marks[f] = map[int]bool{}
+					}
+					h.marks[f][pos] = true
+				}
+			}
+			next[id] = h
+		}
+		hits = next
+	}
+
+	results := make([]searchResult, 0, len(hits))
+	for id, h := range hits {
+		doc := ix.docs[id]
+		if keep != nil && !keep(doc.article) {
+			continue
+		}
+		result := searchResult{Article: doc.article.clone(), Score: h.score, Highlights: map[string]string{}}
+		for f := range doc.fields {
+			if len(h.marks[f]) > 0 {
+				result.Highlights[fieldNames[f]] = snippet(doc.fields[f], h.marks[f])
+			}
+		}
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Article.Id < results[j].Article.Id
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// matchClause returns, per matching article, the token positions that matched in each field.
+// Must be called with ix.mu held.
+func (ix *searchIndex) matchClause(clause queryClause) map[string][numFields][]int {
+	matched := map[string][numFields][]int{}
+	for id, first := range ix.postings[clause.terms[0]] {
+		var positions [numFields][]int
+		found := false
+		for _, f := range clause.fieldsOf() {
+		starts:
+			for _, start := range first[f] {
+				// every following term must sit right after the previous one
+				for i, term := range clause.terms[1:] {
+					p, ok := ix.postings[term][id]
+					if !ok || !containsInt(p[f], start+i+1) {
+						continue starts
+					}
+				}
+				for i := range clause.terms {
+					positions[f] = append(positions[f], start+i)
+				}
+				found = true
+			}
+		}
+		if found {
+			matched[id] = positions
+		}
+	}
+	return matched
+}
+
+// score is the BM25F contribution of one clause to one article.
+// Must be called with ix.mu held.
+func (ix *searchIndex) score(id string, clause queryClause) float64 {
+	n := float64(len(ix.docs))
+	avgLength := ix.totalLength / n
+	doc := ix.docs[id]
+
+	var total float64
+	for _, term := range clause.terms {
+		docs := ix.postings[term]
+		df := float64(len(docs))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		var tf float64
+		for _, f := range clause.fieldsOf() {
+			tf += fieldWeights[f] * float64(len(docs[id][f]))
+		}
+		norm := bm25K1 * (1 - bm25B + bm25B*doc.length/avgLength)
+		total += idf * tf * (bm25K1 + 1) / (tf + norm)
+	}
+	return total
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// number of words shown around the first match in a snippet
+const snippetRadius = 12
+
+// snippet cuts a window of text around the first marked token and wraps marked tokens in <mark>.
+// The text itself is HTML escaped so the snippet can be dropped into a page as is.
+func snippet(field indexedField, marks map[int]bool) string {
+	first := len(field.tokens)
+	for pos := range marks {
+		if pos < first {
+			first = pos
+		}
+	}
+	from, to := first-snippetRadius/2, first+snippetRadius
+	if from < 0 {
+		from = 0
+	}
+	if to > len(field.tokens) {
+		to = len(field.tokens)
+	}
+
+	var b strings.Builder
+	start := 0
+	if from > 0 {
+		b.WriteString("…")
+		start = field.tokens[from].start
+	}
+	cursor := start
+	for pos := from; pos < to; pos++ {
+		tok := field.tokens[pos]
+		b.WriteString(html.EscapeString(field.text[cursor:tok.start]))
+		if marks[pos] {
+			b.WriteString("<mark>" + html.EscapeString(field.text[tok.start:tok.end]) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(field.text[tok.start:tok.end]))
+		}
+		cursor = tok.end
+	}
+	if to < len(field.tokens) {
+		b.WriteString("…")
+	} else {
+		b.WriteString(html.EscapeString(field.text[cursor:]))
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// GET /articles/search?q=...&limit=...
+func (s *server) searchArticles(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: searchArticles")
+	q := r.URL.Query().Get("q")
+	if strings.TrimSpace(q) == "" {
+		http.Error(w, "missing search query q", http.StatusBadRequest)
+		return
+	}
+
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	var keep func(a Article) bool
+	if !wantsUnpublished(r) {
+		keep = isPublished
+	}
+	results := s.index.search(q, limit, keep)
+	for i := range results {
+		results[i].Article = s.present(results[i].Article)
+	}
+	render(w, r, results)
+}
@@ -0,0 +1,282 @@
+// site.go
+package main
+
+import (
+	"bytes"
+	"embed"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultTheme - the templates export-site uses unless -theme has a file of the same name.
+// article.html, index.html, author.html and tag.html are the pages, the others are included by them.
+//
+//go:embed theme
+var defaultTheme embed.FS
+
+// sitePage - what every template of a theme gets
+type sitePage struct {
+	Title    string
+	Root     string // path from the page to the top of the site, "" or "../"
+	Article  *siteArticle
+	Articles []Article
+	Author   *Author
+	Tag      string
+	Tags     []TagCount
+	Authors  []*Author
+}
+
+// siteArticle - an article with its content rendered
+type siteArticle struct {
+	Article
+	Body template.HTML // sanitized by renderMarkdown
+	TOC  []tocEntry
+}
+
+// the paths of the pages, relative to the top of the site
+func articleURL(a Article) string {
+	if a.Slug == "" {
+		return "articles/" + slugify(a.Id) + ".html"
+	}
+	return "articles/" + a.Slug + ".html"
+}
+
+// sitePaths - the pages of the authors and tags. slugify gives different names the same slug
+// ("c", "c++" and "c#" are all "c"), so the names are taken in sorted order and the later ones
+// get "-2", "-3", ... like the slugs of articles with the same title.
+type sitePaths struct {
+	authors map[string]string // by authorKey
+	tags    map[string]string
+}
+
+// newSitePaths names the pages of the authors and tags of articles
+func newSitePaths(articles []Article) sitePaths {
+	authors, tags := map[string]bool{}, map[string]bool{}
+	for _, a := range articles {
+		if a.Author != nil {
+			authors[authorKey(a.Author.Email)] = true
+		}
+		for _, tag := range a.Tags {
+			tags[tag] = true
+		}
+	}
+	return sitePaths{authors: uniquePages("authors", authors), tags: uniquePages("tags", tags)}
+}
+
+// uniquePages gives every name its own page in dir
+func uniquePages(dir string, set map[string]bool) map[string]string {
+	var names []string
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pages, taken := map[string]string{}, map[string]bool{}
+	for _, name := range names {
+		slug := slugify(name)
+		for n := 2; taken[slug]; n++ {
+			slug = fmt.Sprintf("%s-%d", slugify(name), n)
+		}
+		taken[slug] = true
+		pages[name] = dir + "/" + slug + ".html"
+	}
+	return pages
+}
+
+func (p sitePaths) authorURL(a *Author) string {
+	return p.authors[authorKey(a.Email)]
+}
+
+func (p sitePaths) tagURL(tag string) string {
+	return p.tags[tag]
+}
+
+// funcs - the functions the templates of a theme can call
+func (p sitePaths) funcs() template.FuncMap {
+	return template.FuncMap{
+		"articleURL": articleURL,
+		"authorURL":  p.authorURL,
+		"tagURL":     p.tagURL,
+		"date":       func(t time.Time) string { return t.Format("2 January 2006") },
+	}
+}
+
+// loadTheme parses the built-in theme with the files of dir laid over it, dir may be empty.
+// Besides the templates a theme has a style.css, it is returned as it is.
+func loadTheme(dir string, paths sitePaths) (*template.Template, []byte, error) {
+	files := map[string][]byte{}
+	entries, err := fs.ReadDir(defaultTheme, "theme")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		if files[entry.Name()], err = defaultTheme.ReadFile(path.Join("theme", entry.Name())); err != nil {
+			return nil, nil, err
+		}
+	}
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("theme: %v", err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || (filepath.Ext(name) != ".html" && name != "style.css") {
+				continue
+			}
+			if files[name], err = os.ReadFile(filepath.Join(dir, name)); err != nil {
+				return nil, nil, fmt.Errorf("theme: %v", err)
+			}
+		}
+	}
+
+	t := template.New("site").Funcs(paths.funcs())
+	for name, data := range files {
+		if filepath.Ext(name) != ".html" {
+			continue
+		}
+		if _, err := t.New(name).Parse(string(data)); err != nil {
+			return nil, nil, fmt.Errorf("theme: %v", err)
+		}
+	}
+	return t, files["style.css"], nil
+}
+
+// siteWriter writes the pages below out
+type siteWriter struct {
+	out   string
+	theme *template.Template
+}
+
+func (sw siteWriter) write(name string, data []byte) error {
+	full := filepath.Join(sw.out, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(full, data, 0644)
+}
+
+// page executes the template of a page and writes it to name
+func (sw siteWriter) page(name, template string, page sitePage) error {
+	var buf bytes.Buffer
+	if err := sw.theme.ExecuteTemplate(&buf, template, page); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return sw.write(name, buf.Bytes())
+}
+
+// exportSite runs the export-site subcommand: every published article as an HTML page,
+// an index, a page per author and per tag, the feeds and the sitemap, for a static file host.
+func exportSite(args []string) error {
+	flags := flag.NewFlagSet("export-site", flag.ExitOnError)
+	mode, dataDir, contentDir := storageFlags(flags)
+	out := flags.String("out", "public", "directory the site is written to, the articles, authors and tags directories in it are replaced")
+	themeDir := flags.String("theme", "", "directory with templates (and style.css) that replace the built-in ones of the same name")
+	base := flags.String("base-url", "", "URL the site is published at, used for the links in the feeds and the sitemap")
+	title := flags.String("title", "Articles", "title of the index page")
+	flags.Parse(args)
+
+	s, _, err := openServer(*mode, *dataDir, *contentDir, "ulid", true)
+	if err != nil {
+		return err
+	}
+	articles, err := s.feedArticles()
+	if err != nil {
+		return err
+	}
+	paths := newSitePaths(articles)
+	theme, css, err := loadTheme(*themeDir, paths)
+	if err != nil {
+		return err
+	}
+
+	// pages of a previous export whose article, author or tag is gone must not stay online
+	for _, dir := range []string{"articles", "authors", "tags"} {
+		if err := os.RemoveAll(filepath.Join(*out, dir)); err != nil {
+			return err
+		}
+	}
+	sw := siteWriter{out: *out, theme: theme}
+
+	byAuthor := map[string][]Article{}
+	authors := map[string]*Author{}
+	byTag := map[string][]Article{}
+	for _, a := range articles {
+		if a.Author != nil && a.Author.Name == "" {
+			a.Author.Name = a.Author.Email // shared with the listings, they show it too
+		}
+		body, toc, err := renderMarkdown(a.Content)
+		if err != nil {
+			return fmt.Errorf("article %s: %v", a.Id, err)
+		}
+		page := sitePage{Title: a.Title, Root: "../", Article: &siteArticle{Article: a, Body: template.HTML(body), TOC: toc}}
+		if err := sw.page(articleURL(a), "article.html", page); err != nil {
+			return err
+		}
+		if a.Author != nil {
+			byAuthor[a.AuthorId] = append(byAuthor[a.AuthorId], a)
+			authors[a.AuthorId] = a.Author
+		}
+		for _, tag := range a.Tags {
+			byTag[tag] = append(byTag[tag], a)
+		}
+	}
+
+	var authorList []*Author
+	for id, author := range authors {
+		authorList = append(authorList, author)
+		page := sitePage{Title: "Articles by " + author.Name, Root: "../", Articles: byAuthor[id], Author: author}
+		if err := sw.page(paths.authorURL(author), "author.html", page); err != nil {
+			return err
+		}
+	}
+	sort.Slice(authorList, func(i, j int) bool { return strings.ToLower(authorList[i].Name) < strings.ToLower(authorList[j].Name) })
+	for tag, tagged := range byTag {
+		page := sitePage{Title: "Articles tagged " + tag, Root: "../", Articles: tagged, Tag: tag}
+		if err := sw.page(paths.tagURL(tag), "tag.html", page); err != nil {
+			return err
+		}
+	}
+	index := sitePage{Title: *title, Articles: articles, Tags: s.tags.Counts(), Authors: authorList}
+	if err := sw.page("index.html", "index.html", index); err != nil {
+		return err
+	}
+	if err := sw.write("style.css", css); err != nil {
+		return err
+	}
+
+	root := strings.TrimSuffix(*base, "/")
+	site := feedSite{
+		base:     root,
+		self:     root,
+		home:     root + "/index.html",
+		homeType: "text/html",
+		page:     func(a Article) string { return root + "/" + articleURL(a) },
+	}
+	for name, feed := range map[string]interface{}{
+		"feed.atom":   site.atom(articles),
+		"feed.rss":    site.rss(articles),
+		"sitemap.xml": site.sitemap(articles),
+	} {
+		data, err := encodeXML(feed)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if err := sw.write(name, data); err != nil {
+			return err
+		}
+	}
+	if root == "" {
+		log.Printf("export-site: no -base-url, the feeds and the sitemap link relative to the top of the host")
+	}
+	log.Printf("export-site: wrote %d article(s), %d author and %d tag page(s) to %s", len(articles), len(authors), len(byTag), *out)
+	return nil
+}
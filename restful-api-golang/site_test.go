@@ -0,0 +1,98 @@
+// site_test.go
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dirContents maps the files below dir to their contents
+func dirContents(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		files[path] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// TestExportSiteLeavesDataAlone - export-site reads -data, it must not compact, cut or migrate anything there
+func TestExportSiteLeavesDataAlone(t *testing.T) {
+	dir := t.TempDir()
+	s, _, err := openServer("persistent", dir, "", "sequence", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do(newRouter(s), "POST", "/article", `{"title": "Exported", "author": {"Name": "Ann", "Email": "ann@example.com"}}`)
+	s.store.ArticleStore.(*fileStore).journal.Close()
+	s.revisions.log.Close()
+	appendJournal(t, dir, `{"seq":99,"op":"cre`) // a server still writing, or one that crashed
+	if err := os.WriteFile(filepath.Join(dir, "revisions.ndjson"), []byte(`{"number":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before := dirContents(t, dir)
+
+	out := t.TempDir()
+	if err := exportSite([]string{"-mode", "persistent", "-data", dir, "-out", out}); err != nil {
+		t.Fatal(err)
+	}
+	after := dirContents(t, dir)
+	if len(after) != len(before) {
+		t.Errorf("the export changed the files in -data: %d before, %d after", len(before), len(after))
+	}
+	for path, data := range before {
+		if after[path] != data {
+			t.Errorf("the export changed %s", path)
+		}
+	}
+	if index, _ := os.ReadFile(filepath.Join(out, "index.html")); !strings.Contains(string(index), "Exported") {
+		t.Error("the index of the export does not list the article")
+	}
+
+	// git mode without a repository exports the seed articles and creates nothing
+	empty := t.TempDir()
+	if err := exportSite([]string{"-mode", "git", "-data", empty, "-out", t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+	if files := dirContents(t, empty); len(files) != 0 {
+		t.Errorf("the export wrote %d files to an empty -data", len(files))
+	}
+}
+
+func TestSitePathsAreUnique(t *testing.T) {
+	articles := []Article{
+		{Id: "1", Tags: []string{"c", "c++", "c#", "c-2"}, Author: &Author{Email: "a.b@x.com"}},
+		{Id: "2", Tags: []string{"go"}, Author: &Author{Email: "a-b@x.com"}},
+		{Id: "3", Author: &Author{Email: "A.B@x.com"}}, // the same author as article 1
+	}
+	paths := newSitePaths(articles)
+
+	seen := map[string]string{}
+	for _, tag := range []string{"c", "c++", "c#", "c-2", "go"} {
+		page := paths.tagURL(tag)
+		if other, ok := seen[page]; ok || page == "" {
+			t.Errorf("tags %q and %q share the page %q", tag, other, page)
+		}
+		seen[page] = tag
+	}
+	if paths.tagURL("c") != "tags/c.html" || paths.tagURL("go") != "tags/go.html" {
+		t.Errorf("tags without a collision got %s and %s, want their plain slug", paths.tagURL("c"), paths.tagURL("go"))
+	}
+	if a, b := paths.authorURL(articles[0].Author), paths.authorURL(articles[1].Author); a == b {
+		t.Errorf("authors a.b@x.com and a-b@x.com share the page %s", a)
+	}
+	if a, b := paths.authorURL(articles[0].Author), paths.authorURL(articles[2].Author); a != b {
+		t.Errorf("one author got two pages: %s and %s", a, b)
+	}
+}
@@ -0,0 +1,232 @@
+// slug.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/text/unicode/norm"
+)
+
+// longest slug we generate, in runes, longer titles are cut at a word boundary
+const maxSlugLength = 80
+
+// slugify turns a title into a URL slug: lower case, words joined by "-".
+// Diacritics are dropped ("Việt Nam" -> "viet-nam", "đ" -> "d"),
+// letters without a Latin base (Cyrillic, CJK, ...) are kept as they are.
+func slugify(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFD.String(strings.ToLower(title)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue // the accents NFD split off their letters
+		}
+		if r == 'đ' {
+			r = 'd' // đ has no decomposition
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			dash = true
+			continue
+		}
+		if dash && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		dash = false
+		b.WriteRune(r)
+	}
+
+	slug := norm.NFC.String(b.String())
+	if utf8.RuneCountInString(slug) > maxSlugLength {
+		slug = string([]rune(slug)[:maxSlugLength])
+		if i := strings.LastIndex(slug, "-"); i > 0 {
+			slug = slug[:i]
+		}
+	}
+	if slug == "" {
+		return "article"
+	}
+	return slug
+}
+
+// slugIndex remembers every slug an article ever had, so an old URL still finds the article.
+// A slug belongs to one article for good; another article with the same title gets "-2", "-3", ...
+// It is a storeListener: a slug becomes part of the history once an article is saved with it.
+type slugIndex struct {
+	mu      sync.Mutex
+	slugs   map[string]string // slug -> article id
+	pending map[string]string // slugs claimed by a write that has not reached the store yet
+	path    string
+}
+
+// newSlugIndex loads the slug history saved at path, an empty path keeps it in memory only
+func newSlugIndex(path string) (*slugIndex, error) {
+	x := &slugIndex{slugs: map[string]string{}, pending: map[string]string{}, path: path}
+	if path != "" {
+		if err := loadJSONFile(path, &x.slugs); err != nil {
+			return nil, fmt.Errorf("slugs %s: %v", path, err)
+		}
+	}
+	return x, nil
+}
+
+// claim returns the slug for an article with this title: the slug of the title itself,
+// or the first numbered one that is free or already belongs to the article
+func (x *slugIndex) claim(id, title string) string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	base := slugify(title)
+	for n := 1; ; n++ {
+		slug := base
+		if n > 1 {
+			slug = fmt.Sprintf("%s-%d", base, n)
+		}
+		owner, taken := x.slugs[slug]
+		if !taken {
+			owner, taken = x.pending[slug]
+		}
+		if taken && owner != id {
+			continue
+		}
+		if _, saved := x.slugs[slug]; !saved {
+			x.pending[slug] = id
+		}
+		return slug
+	}
+}
+
+// release gives up the pending claims of an article whose write failed
+func (x *slugIndex) release(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for slug, owner := range x.pending {
+		if owner == id {
+			delete(x.pending, slug)
+		}
+	}
+}
+
+func (x *slugIndex) lookup(slug string) (string, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	id, ok := x.slugs[slug]
+	return id, ok
+}
+
+func (x *slugIndex) articleSaved(article Article) {
+	if article.Slug == "" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for slug, owner := range x.pending {
+		if owner == article.Id {
+			delete(x.pending, slug)
+		}
+	}
+	if x.slugs[article.Slug] == article.Id {
+		return
+	}
+	x.slugs[article.Slug] = article.Id
+	if x.path != "" {
+		if err := saveJSONFile(x.path, x.slugs); err != nil {
+			log.Printf("slugs: unable to save the slug %q of article %s: %v", article.Slug, article.Id, err)
+		}
+	}
+}
+
+// the history stays around after a delete, so a restored article keeps its URLs, see forget
+func (x *slugIndex) articleDeleted(id string) {}
+
+// forget frees the slugs of a purged article
+func (x *slugIndex) forget(id string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var freed []string
+	for slug, owner := range x.slugs {
+		if owner == id {
+			freed = append(freed, slug)
+		}
+	}
+	if len(freed) == 0 {
+		return nil
+	}
+	for _, slug := range freed {
+		delete(x.slugs, slug)
+	}
+	if x.path == "" {
+		return nil
+	}
+	if err := saveJSONFile(x.path, x.slugs); err != nil {
+		for _, slug := range freed {
+			x.slugs[slug] = id
+		}
+		return err
+	}
+	return nil
+}
+
+// migrateSlugs gives the articles stored before slugs existed one
+func migrateSlugs(store ArticleStore, slugs *slugIndex) error {
+	articles, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, article := range articles {
+		slugs.articleSaved(article) // the slugs already taken go first
+	}
+	for _, article := range articles {
+		if article.Slug != "" {
+			continue
+		}
+		article.Slug = slugs.claim(article.Id, article.Title)
+		if _, err := store.Update(article.Id, article); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GET /articles/by-slug/{slug} - an old slug answers 301 with the current one
+func (s *server) returnArticleBySlug(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: returnArticleBySlug")
+	slug := mux.Vars(r)["slug"]
+
+	id, ok := s.slugs.lookup(slug)
+	if !ok {
+		writeStoreError(w, ErrArticleNotFound)
+		return
+	}
+	article, err := s.visibleArticle(r, id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if article.Slug != slug {
+		target := "/articles/by-slug/" + url.PathEscape(article.Slug)
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+	localized, ok := s.localizeFor(w, r, article)
+	if !ok {
+		return
+	}
+	if notModified(w, r, article, localized.Locale) {
+		return
+	}
+	render(w, r, s.present(localized))
+}
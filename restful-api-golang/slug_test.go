@@ -0,0 +1,43 @@
+// slug_test.go
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// failingUpdates - a store whose updates fail once fail is set, like a full disk would make them
+type failingUpdates struct {
+	*memoryStore
+	fail bool
+}
+
+func (s *failingUpdates) Update(id string, article Article) (Article, error) {
+	if s.fail {
+		return Article{}, errors.New("disk full")
+	}
+	return s.memoryStore.Update(id, article)
+}
+
+// TestFailedRenameFreesSlug - a write that fails after claiming the slug of its new title must not keep it
+func TestFailedRenameFreesSlug(t *testing.T) {
+	store := &failingUpdates{memoryStore: newMemoryStore(seedArticles()...)}
+	h := newRouter(newTestServer(t, store))
+	store.fail = true
+	for _, req := range []struct{ method, path, body, contentType string }{
+		{"PUT", "/article/1", `{"title": "Fresh Title"}`, "application/json"},
+		{"PATCH", "/article/1", `{"title": "Fresh Title"}`, mergePatchType},
+		{"POST", "/articles/import", `{"Id": "1", "title": "Fresh Title"}`, "application/x-ndjson"},
+	} {
+		w := do(h, req.method, req.path, req.body, "Content-Type", req.contentType)
+		if w.Code == http.StatusOK && req.method != "POST" {
+			t.Fatalf("%s went through a store that fails every update", req.method)
+		}
+	}
+	var created Article
+	decode(t, do(h, "POST", "/article", `{"title": "Fresh Title"}`), &created)
+	if created.Slug != "fresh-title" {
+		t.Errorf("got slug %q, want fresh-title: the failed renames kept it", created.Slug)
+	}
+}
@@ -0,0 +1,280 @@
+// store.go
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrArticleNotFound = errors.New("article not found")
+	ErrArticleExists   = errors.New("article already exists")
+)
+
+// ArticleStore - the storage behind our handlers.
+// Every method must be safe to call from concurrent handler goroutines.
+type ArticleStore interface {
+	Get(id string) (Article, error)
+	List() ([]Article, error)
+	Create(article Article) (Article, error)
+	Update(id string, article Article) (Article, error)
+	Delete(id string) error
+}
+
+// clone copies the article so callers never share the Author, the Tags or PublishAt with the store
+func (a Article) clone() Article {
+	if a.Author != nil {
+		author := *a.Author
+		a.Author = &author
+	}
+	if a.Tags != nil {
+		a.Tags = append([]string{}, a.Tags...)
+	}
+	if a.PublishAt != nil {
+		publishAt := *a.PublishAt
+		a.PublishAt = &publishAt
+	}
+	if a.Translations != nil {
+		translations := make(map[string]Translation, len(a.Translations))
+		for locale, t := range a.Translations {
+			translations[locale] = t
+		}
+		a.Translations = translations
+	}
+	return a
+}
+
+// memoryStore keeps the articles in a slice guarded by a RWMutex,
+// in the same insertion order as the old global Articles slice.
+type memoryStore struct {
+	mu       sync.RWMutex
+	articles []Article
+}
+
+func newMemoryStore(seed ...Article) *memoryStore {
+	s := &memoryStore{}
+	for _, article := range seed {
+		s.articles = append(s.articles, article.clone())
+	}
+	return s
+}
+
+// indexOf must be called with s.mu held
+func (s *memoryStore) indexOf(id string) int {
+	for index, article := range s.articles {
+		if article.Id == id {
+			return index
+		}
+	}
+	return -1
+}
+
+func (s *memoryStore) Get(id string) (Article, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	index := s.indexOf(id)
+	if index < 0 {
+		return Article{}, ErrArticleNotFound
+	}
+	return s.articles[index].clone(), nil
+}
+
+func (s *memoryStore) List() ([]Article, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	articles := make([]Article, 0, len(s.articles))
+	for _, article := range s.articles {
+		articles = append(articles, article.clone())
+	}
+	return articles, nil
+}
+
+func (s *memoryStore) Create(article Article) (Article, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.indexOf(article.Id) >= 0 {
+		return Article{}, ErrArticleExists
+	}
+	s.articles = append(s.articles, article.clone())
+	return article.clone(), nil
+}
+
+func (s *memoryStore) Update(id string, article Article) (Article, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.indexOf(id)
+	if index < 0 {
+		return Article{}, ErrArticleNotFound
+	}
+	article.Id = id
+	s.articles[index] = article.clone()
+	return article.clone(), nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.indexOf(id)
+	if index < 0 {
+		return ErrArticleNotFound
+	}
+	s.articles = append(s.articles[:index], s.articles[index+1:]...)
+	return nil
+}
+
+// storeListener - gets told about every successful write to the store,
+// used to keep derived data (like the search index) in sync with the articles
+type storeListener interface {
+	articleSaved(article Article)
+	articleDeleted(id string)
+}
+
+// notifyingStore wraps an ArticleStore and notifies the listeners after each write.
+// Writes are serialized so the listeners see them in the same order as the store.
+type notifyingStore struct {
+	ArticleStore
+	mu        sync.Mutex
+	listeners []storeListener
+
+	// checkAuthor refuses an AuthorId that has no author. It runs under the write lock,
+	// so an author cannot be deleted between the check and the write, see deleteAuthor.
+	checkAuthor func(authorId string) error
+}
+
+func newNotifyingStore(store ArticleStore) *notifyingStore {
+	return &notifyingStore{ArticleStore: store}
+}
+
+// listen registers l and feeds it the articles that are already in the store
+func (s *notifyingStore) listen(l storeListener) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	articles, err := s.ArticleStore.List()
+	if err != nil {
+		return err
+	}
+	for _, article := range articles {
+		l.articleSaved(article)
+	}
+	s.listeners = append(s.listeners, l)
+	return nil
+}
+
+// locked runs fn under the write lock, fn may only use the methods that must be called with s.mu held
+func (s *notifyingStore) locked(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn()
+}
+
+func (s *notifyingStore) Create(article Article) (Article, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.create(article)
+}
+
+// create must be called with s.mu held
+func (s *notifyingStore) create(article Article) (Article, error) {
+	if article.AuthorId != "" && s.checkAuthor != nil {
+		if err := s.checkAuthor(article.AuthorId); err != nil {
+			return Article{}, err
+		}
+	}
+	article, err := s.ArticleStore.Create(article)
+	if err != nil {
+		return Article{}, err
+	}
+	for _, l := range s.listeners {
+		l.articleSaved(article.clone())
+	}
+	return article, nil
+}
+
+func (s *notifyingStore) Update(id string, article Article) (Article, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(id, article)
+}
+
+// update must be called with s.mu held, the author is only checked when it changes
+func (s *notifyingStore) update(id string, article Article) (Article, error) {
+	if article.AuthorId != "" && s.checkAuthor != nil {
+		current, err := s.ArticleStore.Get(id)
+		if err != nil {
+			return Article{}, err
+		}
+		if authorKey(current.AuthorId) != authorKey(article.AuthorId) {
+			if err := s.checkAuthor(article.AuthorId); err != nil {
+				return Article{}, err
+			}
+		}
+	}
+	article, err := s.ArticleStore.Update(id, article)
+	if err != nil {
+		return Article{}, err
+	}
+	for _, l := range s.listeners {
+		l.articleSaved(article.clone())
+	}
+	return article, nil
+}
+
+// UpdateIf hands the current article to change and writes what it returns,
+// all under the write lock so no other write can slip in between the read and the write.
+// change may refuse with an error, which is returned as is. It must not write to the store itself.
+func (s *notifyingStore) UpdateIf(id string, change func(current Article) (Article, error)) (Article, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.ArticleStore.Get(id)
+	if err != nil {
+		return Article{}, err
+	}
+	article, err := change(current)
+	if err != nil {
+		return Article{}, err
+	}
+	return s.update(id, article)
+}
+
+func (s *notifyingStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.delete(id)
+}
+
+// delete must be called with s.mu held
+func (s *notifyingStore) delete(id string) error {
+	if err := s.ArticleStore.Delete(id); err != nil {
+		return err
+	}
+	for _, l := range s.listeners {
+		l.articleDeleted(id)
+	}
+	return nil
+}
+
+// DeleteIf only deletes the article when check accepts the current version, see UpdateIf
+func (s *notifyingStore) DeleteIf(id string, check func(current Article) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteIf(id, check)
+}
+
+// deleteIf must be called with s.mu held
+func (s *notifyingStore) deleteIf(id string, check func(current Article) error) error {
+	current, err := s.ArticleStore.Get(id)
+	if err != nil {
+		return err
+	}
+	if err := check(current); err != nil {
+		return err
+	}
+	return s.delete(id)
+}
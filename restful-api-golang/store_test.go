@@ -0,0 +1,180 @@
+// store_test.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestServer wires a server around store with every side store in memory and sequential ids
+func newTestServer(t *testing.T, store ArticleStore) *server {
+	t.Helper()
+	authors, _ := newAuthorStore("")
+	revisions, _ := newRevisionStore("")
+	trash, _ := newTrashStore("")
+	comments, _ := newCommentStore("")
+	slugs, _ := newSlugIndex("")
+	engagement, _ := newEngagementStore("")
+	s, err := newServer(store, authors, revisions, trash, comments, slugs, engagement, &sequenceGenerator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// do sends a request to h, header is a list of name, value pairs
+func do(h http.Handler, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// decode reads a JSON response into v or fails the test
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+}
+
+// TestParallelWrites runs create, update and delete through the handlers from many goroutines
+// while others list and search, run it with -race
+func TestParallelWrites(t *testing.T) {
+	s := newTestServer(t, newMemoryStore(seedArticles()...))
+	h := newRouter(s)
+
+	const workers, rounds = 8, 10
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds)
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for round := 0; round < rounds; round++ {
+				body := fmt.Sprintf(`{"title": "worker %d round %d", "content": "first"}`, worker, round)
+				w := do(h, "POST", "/article", body)
+				if w.Code != http.StatusOK {
+					errs <- fmt.Errorf("create: %d %s", w.Code, w.Body)
+					return
+				}
+				var created Article
+				if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+					errs <- err
+					return
+				}
+
+				body = fmt.Sprintf(`{"title": "worker %d round %d", "content": "second"}`, worker, round)
+				w = do(h, "PUT", "/article/"+created.Id, body, "If-Match", w.Header().Get("ETag"))
+				if w.Code != http.StatusOK {
+					errs <- fmt.Errorf("update %s: %d %s", created.Id, w.Code, w.Body)
+					return
+				}
+				if w = do(h, "DELETE", "/article/"+created.Id, ""); w.Code != http.StatusOK {
+					errs <- fmt.Errorf("delete %s: %d %s", created.Id, w.Code, w.Body)
+					return
+				}
+			}
+		}(worker)
+	}
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for _, path := range []string{"/articles", "/articles/search?q=worker", "/tags", "/feed.atom"} {
+		readers.Add(1)
+		go func(path string) {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if w := do(h, "GET", path, ""); w.Code != http.StatusOK {
+					errs <- fmt.Errorf("GET %s: %d %s", path, w.Code, w.Body)
+					return
+				}
+			}
+		}(path)
+	}
+
+	wg.Wait()
+	close(done)
+	readers.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	var articles []Article
+	decode(t, do(h, "GET", "/articles", ""), &articles)
+	if len(articles) != 2 {
+		t.Errorf("got %d articles after every created one was deleted, want the 2 seeded ones", len(articles))
+	}
+	if n := len(s.trash.List()); n != workers*rounds {
+		t.Errorf("got %d articles in the trash, want %d", n, workers*rounds)
+	}
+	if hits := s.index.search("worker", 0, nil); len(hits) != 0 {
+		t.Errorf("the search index still finds %d deleted articles", len(hits))
+	}
+}
+
+// TestUpdateIfLosesNoUpdates checks that concurrent read-modify-writes through UpdateIf all land
+func TestUpdateIfLosesNoUpdates(t *testing.T) {
+	store := newNotifyingStore(newMemoryStore(seedArticles()...))
+
+	const writers = 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.UpdateIf("1", func(current Article) (Article, error) {
+				current.Content += "x"
+				return current, nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	article, err := store.Get("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Article Content" + strings.Repeat("x", writers); article.Content != want {
+		t.Errorf("content is %q, want %d appended x", article.Content, writers)
+	}
+}
+
+func TestMemoryStoreErrors(t *testing.T) {
+	store := newMemoryStore(seedArticles()...)
+	if _, err := store.Create(Article{Id: "1"}); err != ErrArticleExists {
+		t.Errorf("Create of an existing id: got %v, want ErrArticleExists", err)
+	}
+	if _, err := store.Update("nope", Article{}); err != ErrArticleNotFound {
+		t.Errorf("Update of a missing id: got %v, want ErrArticleNotFound", err)
+	}
+	if err := store.Delete("nope"); err != ErrArticleNotFound {
+		t.Errorf("Delete of a missing id: got %v, want ErrArticleNotFound", err)
+	}
+
+	// the store hands out copies, changing one must not change what is stored
+	article, _ := store.Get("1")
+	article.Tags = append(article.Tags, "changed")
+	article.Author.Name = "changed"
+	stored, _ := store.Get("1")
+	if len(stored.Tags) != 0 || stored.Author.Name == "changed" {
+		t.Errorf("a returned article shares memory with the store: %+v", stored)
+	}
+}
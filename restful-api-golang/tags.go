@@ -0,0 +1,158 @@
+// tags.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// normalizeTag lower cases a tag and joins its words with "-", so "Go Lang" and "go-lang" are the same tag
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(tag), "-"))
+}
+
+// normalizeTags drops empty and repeated tags, keeping the order they were given in
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// normalizeTaxonomy cleans up the tags and the category of an article before it is written
+func normalizeTaxonomy(article *Article) {
+	article.Tags = normalizeTags(article.Tags)
+	article.Category = strings.TrimSpace(article.Category)
+}
+
+// hasTags reports whether the article carries all of tags, or any of them when any is set
+func hasTags(article Article, tags []string, any bool) bool {
+	for _, want := range tags {
+		found := false
+		for _, tag := range article.Tags {
+			if tag == want {
+				found = true
+				break
+			}
+		}
+		if found && any {
+			return true
+		}
+		if !found && !any {
+			return false
+		}
+	}
+	return !any || len(tags) == 0
+}
+
+// TagCount - one entry of the tag cloud
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// tagIndex counts how many published articles use every tag.
+// It is a storeListener so the counts follow every write to the store.
+type tagIndex struct {
+	mu     sync.RWMutex
+	tags   map[string][]string // article id -> its tags
+	counts map[string]int
+}
+
+func newTagIndex() *tagIndex {
+	return &tagIndex{tags: make(map[string][]string), counts: make(map[string]int)}
+}
+
+// remove must be called with t.mu held
+func (t *tagIndex) remove(id string) {
+	for _, tag := range t.tags[id] {
+		if t.counts[tag]--; t.counts[tag] <= 0 {
+			delete(t.counts, tag)
+		}
+	}
+	delete(t.tags, id)
+}
+
+func (t *tagIndex) articleSaved(article Article) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.remove(article.Id)
+	if !isPublished(article) {
+		return
+	}
+	tags := normalizeTags(article.Tags)
+	for _, tag := range tags {
+		t.counts[tag]++
+	}
+	t.tags[article.Id] = tags
+}
+
+func (t *tagIndex) articleDeleted(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remove(id)
+}
+
+// Counts returns the tags by use, most used first, ties in alphabetical order
+func (t *tagIndex) Counts() []TagCount {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	counts := make([]TagCount, 0, len(t.counts))
+	for tag, n := range t.counts {
+		counts = append(counts, TagCount{Tag: tag, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Tag < counts[j].Tag
+	})
+	return counts
+}
+
+// GET /tags - the tag cloud
+func (s *server) returnTags(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: returnTags")
+	render(w, r, s.tags.Counts())
+}
+
+// PUT /article/{id}/tags - replaces all tags of the article at once, the body is an array of tags
+func (s *server) replaceArticleTags(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var tags []string
+	if err := decodeBody(r, &tags); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	article, err := s.store.UpdateIf(id, func(current Article) (Article, error) {
+		if err := s.checkIfMatch(r, current); err != nil {
+			return Article{}, err
+		}
+		updated := current.clone()
+		updated.Tags = normalizeTags(tags)
+		stampEdit(r, &updated, current.CreatedAt)
+		return updated, nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", articleETag(article))
+	render(w, r, s.present(article))
+}
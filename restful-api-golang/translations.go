@@ -0,0 +1,335 @@
+// translations.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/text/language"
+)
+
+// Translation - the text of an article in another locale, see Article.Translations
+type Translation struct {
+	Title     string    `json:"title"`
+	Desc      string    `json:"desc"`
+	Content   string    `json:"content"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	UpdatedBy string    `json:"updatedBy,omitempty"`
+}
+
+var (
+	ErrInvalidLocale       = errors.New("locales must be BCP 47 language tags like en-US or vi-VN")
+	ErrTranslationNotFound = errors.New("translation not found")
+)
+
+// canonicalLocale parses a BCP 47 tag and returns it in its canonical form: "vi-vn" -> "vi-VN"
+func canonicalLocale(s string) (string, error) {
+	tag, err := language.Parse(strings.TrimSpace(s))
+	if err != nil || tag == language.Und {
+		return "", fmt.Errorf("%w: %q", ErrInvalidLocale, s)
+	}
+	return tag.String(), nil
+}
+
+// normalizeLocales checks the locale and the translation keys of an article that is about to be written
+func normalizeLocales(article *Article) error {
+	if article.Locale != "" {
+		locale, err := canonicalLocale(article.Locale)
+		if err != nil {
+			return err
+		}
+		article.Locale = locale
+	}
+	if len(article.Translations) == 0 {
+		article.Translations = nil
+		return nil
+	}
+	translations := make(map[string]Translation, len(article.Translations))
+	for key, t := range article.Translations {
+		locale, err := canonicalLocale(key)
+		if err != nil {
+			return err
+		}
+		if locale == article.Locale {
+			return fmt.Errorf("%w: %s is the locale of the article itself", ErrInvalidLocale, locale)
+		}
+		translations[locale] = t
+	}
+	article.Translations = translations
+	return nil
+}
+
+// keepTranslations - a full replace without locale or translations leaves them as they are,
+// clients that do not know about translations must not wipe them
+func keepTranslations(current Article, next *Article) {
+	if next.Locale == "" {
+		next.Locale = current.Locale
+	}
+	if next.Translations == nil {
+		next.Translations = current.Translations
+	}
+}
+
+// requestedLocales - the locales a client asks for: ?lang= (a tag or a list like Accept-Language) wins
+// over the Accept-Language header. None at all means the article's own text.
+func requestedLocales(r *http.Request) ([]language.Tag, error) {
+	v := r.URL.Query().Get("lang")
+	if v == "" {
+		v = r.Header.Get("Accept-Language")
+	}
+	if strings.TrimSpace(v) == "" {
+		return nil, nil
+	}
+	tags, _, err := language.ParseAcceptLanguage(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidLocale, v)
+	}
+	return tags, nil
+}
+
+// translatedLocales - the locales of the translations of an article, sorted
+func translatedLocales(article Article) []string {
+	locales := make([]string, 0, len(article.Translations))
+	for locale := range article.Translations {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// localize returns the article in the locale that best matches wanted.
+// The fallback chain is the one of the language matcher: the exact locale, then the closest one
+// of the same language (vi -> vi-VN, en-AU -> en-GB), then the article's own text.
+// Fields a translation leaves empty come from the article.
+// An article without a locale is taken to be in defaultLocale, unless that is empty too.
+func localize(article Article, wanted []language.Tag, defaultLocale string) Article {
+	if len(article.Translations) == 0 || len(wanted) == 0 {
+		return article
+	}
+	locales := translatedLocales(article)
+	supported := []language.Tag{language.Und} // the article itself, also when its locale is unknown
+	if own := localeOf(article, defaultLocale); own != "" {
+		supported[0] = language.Make(own)
+	}
+	for _, locale := range locales {
+		supported = append(supported, language.Make(locale))
+	}
+	_, index, confidence := language.NewMatcher(supported).Match(wanted...)
+	if confidence == language.No || index == 0 {
+		return article
+	}
+
+	locale := locales[index-1]
+	t := article.Translations[locale]
+	if t.Title != "" {
+		article.Title = t.Title
+	}
+	if t.Desc != "" {
+		article.Desc = t.Desc
+	}
+	if t.Content != "" {
+		article.Content = t.Content
+	}
+	article.Locale = locale
+	return article
+}
+
+// localeOf - the locale of the article's own text, defaultLocale when it has none
+func localeOf(article Article, defaultLocale string) string {
+	if article.Locale == "" {
+		return defaultLocale
+	}
+	return article.Locale
+}
+
+// localizeFor picks the locale of a single article for the response and sets the headers for it,
+// it answers 400 itself for a malformed ?lang= or Accept-Language
+func (s *server) localizeFor(w http.ResponseWriter, r *http.Request, article Article) (Article, bool) {
+	wanted, err := requestedLocales(r)
+	if err != nil {
+		writeStoreError(w, err)
+		return Article{}, false
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	article = localize(article, wanted, s.defaultLocale)
+	if locale := localeOf(article, s.defaultLocale); locale != "" {
+		w.Header().Set("Content-Language", locale)
+	}
+	return article, true
+}
+
+// GET /article/{id}/translations - all translations by locale
+func (s *server) returnArticleTranslations(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: returnArticleTranslations")
+	article, err := s.visibleArticle(r, mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	translations := article.Translations
+	if translations == nil {
+		translations = map[string]Translation{}
+	}
+	render(w, r, translations)
+}
+
+// GET /article/{id}/translations/{locale}
+func (s *server) returnArticleTranslation(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: returnArticleTranslation")
+	vars := mux.Vars(r)
+	locale, err := canonicalLocale(vars["locale"])
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	article, err := s.visibleArticle(r, vars["id"])
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	t, ok := article.Translations[locale]
+	if !ok {
+		writeStoreError(w, ErrTranslationNotFound)
+		return
+	}
+	w.Header().Set("Content-Language", locale)
+	render(w, r, t)
+}
+
+// PUT /article/{id}/translations/{locale} - adds or replaces one translation
+func (s *server) putArticleTranslation(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: putArticleTranslation")
+	vars := mux.Vars(r)
+	locale, err := canonicalLocale(vars["locale"])
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	var t Translation
+	if err := decodeBody(r, &t); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if t.Title == "" && t.Desc == "" && t.Content == "" {
+		http.Error(w, "a translation needs a title, desc or content", http.StatusBadRequest)
+		return
+	}
+
+	article, err := s.store.UpdateIf(vars["id"], func(current Article) (Article, error) {
+		if err := s.checkIfMatch(r, current); err != nil {
+			return Article{}, err
+		}
+		if locale == current.Locale {
+			return Article{}, fmt.Errorf("%w: %s is the locale of the article itself", ErrInvalidLocale, locale)
+		}
+		updated := current.clone()
+		stampEdit(r, &updated, current.CreatedAt)
+		t.UpdatedAt, t.UpdatedBy = updated.UpdatedAt, updated.UpdatedBy
+		if updated.Translations == nil {
+			updated.Translations = map[string]Translation{}
+		}
+		updated.Translations[locale] = t
+		return updated, nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", articleETag(article))
+	render(w, r, s.present(article))
+}
+
+// DELETE /article/{id}/translations/{locale}
+func (s *server) deleteArticleTranslation(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: deleteArticleTranslation")
+	vars := mux.Vars(r)
+	locale, err := canonicalLocale(vars["locale"])
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	article, err := s.store.UpdateIf(vars["id"], func(current Article) (Article, error) {
+		if err := s.checkIfMatch(r, current); err != nil {
+			return Article{}, err
+		}
+		if _, ok := current.Translations[locale]; !ok {
+			return Article{}, ErrTranslationNotFound
+		}
+		updated := current.clone()
+		delete(updated.Translations, locale)
+		if len(updated.Translations) == 0 {
+			updated.Translations = nil
+		}
+		stampEdit(r, &updated, current.CreatedAt)
+		return updated, nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", articleETag(article))
+	render(w, r, s.present(article))
+}
+
+// missingLocales - the answer of GET /article/{id}/translations/missing
+type missingLocales struct {
+	Available []string `json:"available"` // the article's own locale and its translations
+	Missing   []string `json:"missing"`
+}
+
+// GET /article/{id}/translations/missing?locales=en-US,vi-VN - the locales the article has no text in yet,
+// checked against ?locales= or else the locales the server was started with (-locales)
+func (s *server) returnMissingTranslations(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: returnMissingTranslations")
+	wanted := s.locales
+	if v := r.URL.Query().Get("locales"); v != "" {
+		var err error
+		if wanted, err = parseLocales(v); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	if len(wanted) == 0 {
+		http.Error(w, "no locales to check, pass ?locales= or start the server with -locales", http.StatusBadRequest)
+		return
+	}
+
+	article, err := s.visibleArticle(r, mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	result := missingLocales{Available: translatedLocales(article), Missing: []string{}}
+	own := localeOf(article, s.defaultLocale)
+	if own != "" {
+		result.Available = append([]string{own}, result.Available...)
+	}
+	for _, locale := range wanted {
+		if _, ok := article.Translations[locale]; !ok && locale != own {
+			result.Missing = append(result.Missing, locale)
+		}
+	}
+	render(w, r, result)
+}
+
+// parseLocales reads a comma separated list of locales
+func parseLocales(v string) ([]string, error) {
+	var locales []string
+	for _, part := range strings.Split(v, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		locale, err := canonicalLocale(part)
+		if err != nil {
+			return nil, err
+		}
+		locales = append(locales, locale)
+	}
+	return locales, nil
+}
@@ -0,0 +1,96 @@
+// translations_test.go
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newTranslatedArticle creates an English article with a Vietnamese translation
+func newTranslatedArticle(t *testing.T, h http.Handler, body string) Article {
+	t.Helper()
+	var created Article
+	decode(t, do(h, "POST", "/article", body), &created)
+	if w := do(h, "PUT", "/article/"+created.Id+"/translations/vi-VN", `{"title": "Xin chào"}`); w.Code != http.StatusOK {
+		t.Fatalf("translate: %d %s", w.Code, w.Body)
+	}
+	return created
+}
+
+// TestETagDependsOnLocaleAndFormat - every representation of an article has its own ETag,
+// and a write may name any of them in If-Match
+func TestETagDependsOnLocaleAndFormat(t *testing.T) {
+	h := newRouter(newTestServer(t, newMemoryStore()))
+	article := newTranslatedArticle(t, h, `{"title": "Hello", "locale": "en"}`)
+	path := "/article/" + article.Id
+
+	english := do(h, "GET", path, "", "Accept-Language", "en").Header().Get("ETag")
+	vietnamese := do(h, "GET", path, "", "Accept-Language", "vi").Header().Get("ETag")
+	xml := do(h, "GET", path, "", "Accept-Language", "en", "Accept", "application/xml").Header().Get("ETag")
+	if english == vietnamese || english == xml || vietnamese == xml {
+		t.Fatalf("representations share ETags: en %s, vi %s, en as XML %s", english, vietnamese, xml)
+	}
+
+	if w := do(h, "GET", path, "", "Accept-Language", "vi", "If-None-Match", english); w.Code != http.StatusOK {
+		t.Errorf("the English ETag revalidated the Vietnamese article: %d", w.Code)
+	}
+	if w := do(h, "GET", path, "", "Accept-Language", "vi", "If-None-Match", vietnamese); w.Code != http.StatusNotModified {
+		t.Errorf("revalidating the Vietnamese article: got %d, want 304", w.Code)
+	}
+
+	if w := do(h, "PUT", path, `{"title": "Hello again", "locale": "en"}`, "If-Match", vietnamese); w.Code != http.StatusOK {
+		t.Errorf("a write with the ETag of a translation was refused: %d %s", w.Code, w.Body)
+	}
+	if w := do(h, "PUT", path, `{"title": "Hello 3", "locale": "en"}`, "If-Match", xml); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("a write with an ETag of an older version: got %d, want 412", w.Code)
+	}
+}
+
+// TestUntaggedArticleHasDefaultLocale - an article without a locale is in -default-locale,
+// a reader who prefers that language gets its own text and not a translation
+func TestUntaggedArticleHasDefaultLocale(t *testing.T) {
+	s := newTestServer(t, newMemoryStore())
+	h := newRouter(s)
+	article := newTranslatedArticle(t, h, `{"title": "Hello"}`)
+	path := "/article/" + article.Id
+
+	var got Article
+	s.defaultLocale = "en"
+	w := do(h, "GET", path, "", "Accept-Language", "en-US,vi;q=0.5")
+	decode(t, w, &got)
+	if got.Title != "Hello" || w.Header().Get("Content-Language") != "en" {
+		t.Errorf("got %q in %q, want the English text", got.Title, w.Header().Get("Content-Language"))
+	}
+	decode(t, do(h, "GET", path, "", "Accept-Language", "vi"), &got)
+	if got.Title != "Xin chào" {
+		t.Errorf("got %q for a Vietnamese reader, want the translation", got.Title)
+	}
+
+	var missing missingLocales
+	decode(t, do(h, "GET", path+"/translations/missing?locales=en,vi-VN,fr", ""), &missing)
+	if len(missing.Missing) != 1 || missing.Missing[0] != "fr" {
+		t.Errorf("missing translations %v, want [fr]", missing.Missing)
+	}
+}
+
+func TestDraftTranslationsAreHidden(t *testing.T) {
+	h := newRouter(newTestServer(t, newMemoryStore()))
+	article := newTranslatedArticle(t, h, `{"title": "Draft", "status": "draft"}`)
+	for _, path := range []string{
+		"/article/" + article.Id + "/translations",
+		"/article/" + article.Id + "/translations/vi-VN",
+		"/article/" + article.Id + "/translations/missing?locales=fr",
+	} {
+		if w := do(h, "GET", path, ""); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s of a draft: got %d, want 404", path, w.Code)
+		}
+		preview := path + "?preview=true"
+		if strings.Contains(path, "?") {
+			preview = path + "&preview=true"
+		}
+		if w := do(h, "GET", preview, ""); w.Code != http.StatusOK {
+			t.Errorf("GET %s of a draft: got %d, want 200", preview, w.Code)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// trash.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var ErrNotInTrash = errors.New("article is not in the trash")
+
+// TrashEntry - a deleted article waiting in the trash until it is restored or purged
+type TrashEntry struct {
+	Article   Article   `json:"article"`
+	DeletedAt time.Time `json:"deletedAt"`
+	DeletedBy string    `json:"deletedBy"`
+
+	Comments []Comment `json:"comments,omitempty"` // the article's comments, deleting the article removes them from the comment store
+	Author   *Author   `json:"author,omitempty"`   // the author record as it was, a restore brings it back when it was deleted since
+}
+
+// trashStore keeps the deleted articles, in persistent mode it also rewrites path on every change
+type trashStore struct {
+	mu      sync.Mutex
+	entries []TrashEntry
+	path    string
+}
+
+// newTrashStore loads the trash saved at path, an empty path keeps it in memory only
+func newTrashStore(path string) (*trashStore, error) {
+	t := &trashStore{path: path}
+	if path != "" {
+		if err := loadJSONFile(path, &t.entries); err != nil {
+			return nil, fmt.Errorf("trash %s: %v", path, err)
+		}
+	}
+	return t, nil
+}
+
+// save must be called with t.mu held
+func (t *trashStore) save() error {
+	if t.path == "" {
+		return nil
+	}
+	return saveJSONFile(t.path, t.entries)
+}
+
+// indexOf must be called with t.mu held
+func (t *trashStore) indexOf(id string) int {
+	for index, entry := range t.entries {
+		if entry.Article.Id == id {
+			return index
+		}
+	}
+	return -1
+}
+
+func (t *trashStore) Add(entry TrashEntry) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry.Article = entry.Article.clone()
+	entry.Article.Author = nil
+	old := t.entries
+	if index := t.indexOf(entry.Article.Id); index >= 0 {
+		t.entries = append(append([]TrashEntry{}, t.entries[:index]...), t.entries[index+1:]...)
+	}
+	t.entries = append(t.entries, entry)
+	if err := t.save(); err != nil {
+		t.entries = old
+		return err
+	}
+	return nil
+}
+
+func (t *trashStore) List() []TrashEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TrashEntry{}, t.entries...)
+}
+
+func (t *trashStore) Get(id string) (TrashEntry, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	index := t.indexOf(id)
+	if index < 0 {
+		return TrashEntry{}, ErrNotInTrash
+	}
+	return t.entries[index], nil
+}
+
+func (t *trashStore) Remove(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	index := t.indexOf(id)
+	if index < 0 {
+		return ErrNotInTrash
+	}
+	old := t.entries
+	t.entries = append(append([]TrashEntry{}, t.entries[:index]...), t.entries[index+1:]...)
+	if err := t.save(); err != nil {
+		t.entries = old
+		return err
+	}
+	return nil
+}
+
+// purge removes every entry deleted before cutoff and returns the ids of the articles that went
+func (t *trashStore) purge(cutoff time.Time) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var (
+		kept   []TrashEntry
+		purged []string
+	)
+	for _, entry := range t.entries {
+		if entry.DeletedAt.After(cutoff) {
+			kept = append(kept, entry)
+		} else {
+			purged = append(purged, entry.Article.Id)
+		}
+	}
+	if len(purged) == 0 {
+		return nil, nil
+	}
+	old := t.entries
+	t.entries = kept
+	if err := t.save(); err != nil {
+		t.entries = old
+		return nil, err
+	}
+	return purged, nil
+}
+
+// purgeEvery runs in the background and empties the trash of entries older than retention
+func (s *server) purgeEvery(interval, retention time.Duration) {
+	for range time.Tick(interval) {
+		ids, err := s.trash.purge(time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("trash: purge failed: %v", err)
+			continue
+		}
+		for _, id := range ids {
+			s.forgetArticle(id)
+		}
+		if len(ids) > 0 {
+			log.Printf("trash: purged %d article(s) older than %s", len(ids), retention)
+		}
+	}
+}
+
+// forgetArticle drops what the side stores kept of a purged article for a restore:
+// its revisions, its slugs and its view and like counters.
+// It runs under the article write lock, so a restore that got the entry before the purge
+// either created the article before the check or finds the entry gone, see restoreArticle.
+func (s *server) forgetArticle(id string) {
+	s.store.locked(func() error {
+		if _, err := s.store.ArticleStore.Get(id); err == nil {
+			return nil // an article with the same id was created since, all of it belongs to that one now
+		}
+		if err := s.revisions.forget(id); err != nil {
+			log.Printf("trash: unable to drop the revisions of purged article %s: %v", id, err)
+		}
+		if err := s.slugs.forget(id); err != nil {
+			log.Printf("trash: unable to drop the slugs of purged article %s: %v", id, err)
+		}
+		s.engagement.forget(id)
+		return nil
+	})
+}
+
+// trashArticle moves an article from the store into the trash.
+// The trash entry is written first, so a crash in between leaves a copy in both places rather than in neither.
+func (s *server) trashArticle(r *http.Request, id string, check func(current Article) error) (TrashEntry, error) {
+	var entry TrashEntry
+	err := s.store.locked(func() error {
+		var err error
+		entry, err = s.trashLocked(r, id, check)
+		return err
+	})
+	return entry, err
+}
+
+// trashLocked is trashArticle for callers that hold the article write lock already, see notifyingStore.locked
+func (s *server) trashLocked(r *http.Request, id string, check func(current Article) error) (TrashEntry, error) {
+	var entry TrashEntry
+	err := s.store.deleteIf(id, func(current Article) error {
+		if check != nil {
+			if err := check(current); err != nil {
+				return err
+			}
+		}
+		entry = TrashEntry{Article: current, DeletedAt: time.Now().UTC(), DeletedBy: editorOf(r, current), Comments: s.comments.ForArticle(id)}
+		if author, err := s.authors.Get(current.AuthorId); err == nil {
+			entry.Author = &author
+		}
+		return s.trash.Add(entry)
+	})
+	if err != nil {
+		if entry.Article.Id != "" {
+			s.trash.Remove(id) // the delete itself failed, the article is still in the store
+		}
+		return TrashEntry{}, err
+	}
+	return entry, nil
+}
+
+func (s *server) returnTrash(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Endpoint Hit: returnTrash")
+	render(w, r, s.trash.List())
+}
+
+// POST /trash/{id}/restore - puts the article back under its old id
+func (s *server) restoreArticle(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	entry, err := s.trash.Get(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// the author may be gone by now, deleted together with the article for one: it is brought back
+	// from the trash entry the way an embedded author is created on a write
+	article := entry.Article
+	article.Author = entry.Author
+	if err := s.resolveAuthor(&article); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	// the entry is looked up again under the article write lock: a purge in between
+	// has dropped the revisions and slugs the restored article would come back to
+	err = s.store.locked(func() error {
+		if _, err := s.trash.Get(id); err != nil {
+			return err
+		}
+		var err error
+		article, err = s.store.create(article)
+		return err
+	})
+	if err == ErrNotInTrash {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := s.comments.restore(entry.Comments); err != nil {
+		log.Printf("trash: the comments of restored article %s could not be restored: %v", id, err)
+	}
+	if err := s.trash.Remove(id); err != nil && err != ErrNotInTrash {
+		log.Printf("trash: restored article %s could not be removed from the trash: %v", id, err)
+	}
+	w.Header().Set("ETag", articleETag(article))
+	render(w, r, s.present(article))
+}
+
+// DELETE /trash/{id} - purges one article right away
+func (s *server) purgeArticle(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := s.trash.Remove(id); err != nil {
+		if err == ErrNotInTrash {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.forgetArticle(id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,103 @@
+// trash_test.go
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// TestPurgeDropsDerivedData - a purged article leaves no revisions, slugs or counters behind, also after a restart
+func TestPurgeDropsDerivedData(t *testing.T) {
+	dir := t.TempDir()
+	s, _, err := openServer("persistent", dir, "", "sequence", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newRouter(s)
+
+	var kept, purged Article
+	decode(t, do(h, "POST", "/article", `{"title": "Kept"}`), &kept)
+	decode(t, do(h, "POST", "/article", `{"title": "Purged"}`), &purged)
+	do(h, "PUT", "/article/"+purged.Id, `{"title": "Purged again"}`)
+	do(h, "POST", "/article/"+purged.Id+"/like", "")
+	do(h, "DELETE", "/article/"+purged.Id, "")
+	if w := do(h, "GET", "/article/"+purged.Id+"/revisions?preview=true", ""); w.Code != http.StatusOK {
+		t.Fatalf("a trashed article lost its revisions before the purge: %d", w.Code)
+	}
+
+	if w := do(h, "DELETE", "/trash/"+purged.Id, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("purge: %d %s", w.Code, w.Body)
+	}
+	check := func(s *server) {
+		t.Helper()
+		if revs := s.revisions.List(purged.Id); len(revs) != 0 {
+			t.Errorf("the purged article still has %d revisions", len(revs))
+		}
+		if revs := s.revisions.List(kept.Id); len(revs) != 1 {
+			t.Errorf("the article next to it has %d revisions, want 1", len(revs))
+		}
+		for _, slug := range []string{"purged", "purged-again"} {
+			if _, ok := s.slugs.lookup(slug); ok {
+				t.Errorf("slug %s of the purged article is still taken", slug)
+			}
+		}
+		if counts := s.engagement.counts(purged.Id); counts.Likes != 0 {
+			t.Errorf("the purged article still has %d likes", counts.Likes)
+		}
+	}
+	check(s)
+
+	if err := s.engagement.flush(); err != nil {
+		t.Fatal(err)
+	}
+	s.store.ArticleStore.(*fileStore).journal.Close()
+	s.revisions.log.Close()
+	s, _, err = openServer("persistent", dir, "", "sequence", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.store.ArticleStore.(*fileStore).journal.Close()
+		s.revisions.log.Close()
+	})
+	check(s)
+}
+
+// TestPurgeRacesRestore - a restore running next to the purge of the same article either
+// brings the article back with its revisions and slug, or is refused because the entry is gone
+func TestPurgeRacesRestore(t *testing.T) {
+	for round := 0; round < 50; round++ {
+		s := newTestServer(t, newMemoryStore())
+		h := newRouter(s)
+		var created Article
+		decode(t, do(h, "POST", "/article", `{"title": "Racing"}`), &created)
+		do(h, "DELETE", "/article/"+created.Id, "")
+
+		var wg sync.WaitGroup
+		var restored int
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			restored = do(h, "POST", "/trash/"+created.Id+"/restore", "").Code
+		}()
+		go func() {
+			defer wg.Done()
+			do(h, "DELETE", "/trash/"+created.Id, "")
+		}()
+		wg.Wait()
+
+		if _, err := s.store.Get(created.Id); err != nil {
+			if restored == http.StatusOK {
+				t.Fatalf("round %d: the restore answered 200 but the article is missing", round)
+			}
+			continue
+		}
+		if revs := s.revisions.List(created.Id); len(revs) == 0 {
+			t.Fatalf("round %d: the restored article lost its revisions to the purge", round)
+		}
+		if id, ok := s.slugs.lookup("racing"); !ok || id != created.Id {
+			t.Fatalf("round %d: the restored article lost its slug to the purge", round)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// workflow.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// the states an article moves through before and after it goes live
+const (
+	StatusDraft     = "draft"
+	StatusInReview  = "in_review"
+	StatusPublished = "published"
+	StatusArchived  = "archived"
+)
+
+// transitions - the status changes an editor may make, staying in the same status is always fine.
+// in_review -> published also happens on its own once publishAt has passed, see publishDue.
+var transitions = map[string][]string{
+	StatusDraft:     {StatusInReview, StatusArchived},
+	StatusInReview:  {StatusDraft, StatusPublished},
+	StatusPublished: {StatusDraft, StatusArchived},
+	StatusArchived:  {StatusDraft},
+}
+
+var (
+	ErrInvalidStatus     = errors.New("status must be draft, in_review, published or archived")
+	ErrInvalidTransition = errors.New("this status change is not allowed")
+	ErrPublishAtFuture   = errors.New("publishAt is in the future, leave the article in_review and it is published then")
+)
+
+// statusOf - articles stored before the workflow existed have no status and count as published
+func statusOf(article Article) string {
+	if article.Status == "" {
+		return StatusPublished
+	}
+	return article.Status
+}
+
+func isPublished(article Article) bool {
+	return statusOf(article) == StatusPublished
+}
+
+// wantsUnpublished - unpublished articles are only served to callers who ask with ?preview=true
+func wantsUnpublished(r *http.Request) bool {
+	return r.URL.Query().Get("preview") == "true"
+}
+
+// visibleArticle returns the article as GET /article/{id} would: an unpublished one only with ?preview=true.
+// The endpoints that serve what belongs to an article (revisions, comments, translations) check it first.
+func (s *server) visibleArticle(r *http.Request, id string) (Article, error) {
+	article, err := s.store.Get(id)
+	if err == nil && !isPublished(article) && !wantsUnpublished(r) {
+		return Article{}, ErrArticleNotFound
+	}
+	return article, err
+}
+
+// applyWorkflow checks the status of an article about to be written and fills in what is missing.
+// current is nil for a new article: without a status it is published right away,
+// or put in_review when publishAt lies in the future.
+func applyWorkflow(current *Article, next *Article, now time.Time) error {
+	if next.Status == "" {
+		switch {
+		case current != nil:
+			next.Status = statusOf(*current)
+		case next.PublishAt != nil && next.PublishAt.After(now):
+			next.Status = StatusInReview
+		default:
+			next.Status = StatusPublished
+		}
+	}
+	if _, ok := transitions[next.Status]; !ok {
+		return ErrInvalidStatus
+	}
+
+	if current != nil {
+		from := statusOf(*current)
+		allowed := from == next.Status
+		for _, to := range transitions[from] {
+			allowed = allowed || to == next.Status
+		}
+		if !allowed {
+			return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, next.Status)
+		}
+	}
+
+	if next.Status == StatusPublished {
+		if next.PublishAt != nil && next.PublishAt.After(now) {
+			return ErrPublishAtFuture
+		}
+		if next.PublishAt == nil {
+			published := now
+			next.PublishAt = &published
+		}
+	} else if next.PublishAt != nil && !next.PublishAt.After(now) && (current == nil || statusOf(*current) != next.Status) {
+		// a publishAt that has passed is left over from an earlier publication or schedule,
+		// kept on an article entering review it would make publishDue publish it unreviewed
+		next.PublishAt = nil
+	}
+	return nil
+}
+
+// statusChange - the body of PUT /article/{id}/status
+type statusChange struct {
+	Status    string     `json:"status"`
+	PublishAt *time.Time `json:"publishAt"`
+}
+
+// PUT /article/{id}/status - moves the article to another status, a publishAt in the body (re)schedules it
+func (s *server) changeArticleStatus(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var change statusChange
+	if err := decodeBody(r, &change); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if change.Status == "" {
+		http.Error(w, "the body needs a status", http.StatusBadRequest)
+		return
+	}
+
+	article, err := s.store.UpdateIf(id, func(current Article) (Article, error) {
+		if err := s.checkIfMatch(r, current); err != nil {
+			return Article{}, err
+		}
+		updated := current.clone()
+		updated.Status = change.Status
+		if change.PublishAt != nil {
+			updated.PublishAt = change.PublishAt
+		}
+		if err := applyWorkflow(&current, &updated, time.Now().UTC()); err != nil {
+			return Article{}, err
+		}
+		stampEdit(r, &updated, current.CreatedAt)
+		return updated, nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", articleETag(article))
+	render(w, r, s.present(article))
+}
+
+// publishDue publishes the articles in review whose publishAt has passed and returns how many it published
+func (s *server) publishDue(now time.Time) (int, error) {
+	articles, err := s.store.List()
+	if err != nil {
+		return 0, err
+	}
+	due := func(a Article) bool {
+		return statusOf(a) == StatusInReview && a.PublishAt != nil && !a.PublishAt.After(now)
+	}
+
+	published := 0
+	for _, article := range articles {
+		if !due(article) {
+			continue
+		}
+		_, err := s.store.UpdateIf(article.Id, func(current Article) (Article, error) {
+			if !due(current) {
+				return Article{}, errNotDue // changed since the List above
+			}
+			updated := current.clone()
+			updated.Status = StatusPublished
+			updated.UpdatedAt, updated.UpdatedBy = now, "scheduler"
+			return updated, nil
+		})
+		if err == errNotDue || err == ErrArticleNotFound {
+			continue
+		}
+		if err != nil {
+			return published, err
+		}
+		published++
+	}
+	return published, nil
+}
+
+var errNotDue = errors.New("article is no longer due")
+
+// publishEvery runs in the background and publishes the scheduled articles as their time arrives
+func (s *server) publishEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		n, err := s.publishDue(time.Now().UTC())
+		if err != nil {
+			log.Printf("scheduler: publishing failed: %v", err)
+		}
+		if n > 0 {
+			log.Printf("scheduler: published %d article(s)", n)
+		}
+	}
+}
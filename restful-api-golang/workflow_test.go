@@ -0,0 +1,113 @@
+// workflow_test.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestUnpublishedArticleIsNotRepublished - an article taken back to draft and sent to review
+// waits for the review, the publishAt of its first publication must not schedule it again
+func TestUnpublishedArticleIsNotRepublished(t *testing.T) {
+	s := newTestServer(t, newMemoryStore())
+	h := newRouter(s)
+	var created Article
+	decode(t, do(h, "POST", "/article", `{"title": "Post"}`), &created)
+	if created.PublishAt == nil {
+		t.Fatal("a published article has no publishAt")
+	}
+
+	for _, status := range []string{StatusDraft, StatusInReview} {
+		if w := do(h, "PUT", "/article/"+created.Id+"/status", `{"status": "`+status+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("status %s: %d %s", status, w.Code, w.Body)
+		}
+	}
+	if n, err := s.publishDue(time.Now().UTC().Add(time.Hour)); err != nil || n != 0 {
+		t.Fatalf("publishDue published %d articles (%v), want none", n, err)
+	}
+	if article, _ := s.store.Get(created.Id); statusOf(article) != StatusInReview {
+		t.Errorf("article is %s, want it still in review", statusOf(article))
+	}
+}
+
+func TestScheduledArticleIsPublished(t *testing.T) {
+	s := newTestServer(t, newMemoryStore())
+	h := newRouter(s)
+	at := time.Now().UTC().Add(time.Hour)
+	var created Article
+	decode(t, do(h, "POST", "/article", fmt.Sprintf(`{"title": "Later", "publishAt": %q}`, at.Format(time.RFC3339))), &created)
+	if created.Status != StatusInReview {
+		t.Fatalf("an article with a future publishAt is %s, want in_review", created.Status)
+	}
+
+	if n, _ := s.publishDue(at.Add(-time.Minute)); n != 0 {
+		t.Errorf("published %d articles before their time", n)
+	}
+	if n, _ := s.publishDue(at); n != 1 {
+		t.Errorf("published %d articles once publishAt passed, want 1", n)
+	}
+	if article, _ := s.store.Get(created.Id); !isPublished(article) {
+		t.Errorf("scheduled article is %s, want published", statusOf(article))
+	}
+}
+
+// TestDraftHistoryAndCommentsAreHidden - what belongs to a draft is served like the draft itself, only with ?preview=true
+func TestDraftHistoryAndCommentsAreHidden(t *testing.T) {
+	s := newTestServer(t, newMemoryStore())
+	h := newRouter(s)
+	var created Article
+	decode(t, do(h, "POST", "/article", `{"title": "Draft", "status": "draft", "author": {"Name": "Ann", "Email": "ann@example.com"}}`), &created)
+	var comment Comment
+	decode(t, do(h, "POST", "/article/"+created.Id+"/comments?preview=true", `{"body": "early", "authorId": "ann@example.com"}`), &comment)
+
+	for _, path := range []string{
+		"/article/" + created.Id + "/revisions",
+		"/article/" + created.Id + "/revisions/1",
+		"/article/" + created.Id + "/diff",
+		"/article/" + created.Id + "/comments",
+		"/article/" + created.Id + "/comments/" + comment.Id,
+	} {
+		if w := do(h, "GET", path, ""); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s of a draft: got %d, want 404", path, w.Code)
+		}
+		if w := do(h, "GET", path+"?preview=true", ""); w.Code != http.StatusOK {
+			t.Errorf("GET %s?preview=true of a draft: got %d, want 200", path, w.Code)
+		}
+	}
+}
+
+// TestDraftCommentsAreNotWritable - comment writes on a draft need ?preview=true like its reads
+func TestDraftCommentsAreNotWritable(t *testing.T) {
+	s := newTestServer(t, newMemoryStore())
+	h := newRouter(s)
+	var created Article
+	decode(t, do(h, "POST", "/article", `{"title": "Draft", "status": "draft", "author": {"Name": "Ann", "Email": "ann@example.com"}}`), &created)
+	var comment Comment
+	decode(t, do(h, "POST", "/article/"+created.Id+"/comments?preview=true", `{"body": "early", "authorId": "ann@example.com"}`), &comment)
+
+	path := "/article/" + created.Id + "/comments"
+	if w := do(h, "POST", path, `{"body": "hello", "authorId": "ann@example.com"}`); w.Code != http.StatusNotFound {
+		t.Errorf("POST %s of a draft: got %d, want 404", path, w.Code)
+	}
+	if w := do(h, "PUT", path+"/"+comment.Id, `{"body": "edited"}`); w.Code != http.StatusNotFound {
+		t.Errorf("PUT %s/%s of a draft: got %d, want 404", path, comment.Id, w.Code)
+	}
+	if w := do(h, "DELETE", path+"/"+comment.Id, ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE %s/%s of a draft: got %d, want 404", path, comment.Id, w.Code)
+	}
+	if stored, err := s.comments.Get(comment.Id); err != nil || stored.Body != "early" {
+		t.Errorf("the comment of a draft changed without ?preview=true: %+v, %v", stored, err)
+	}
+	if n := len(s.comments.ForArticle(created.Id)); n != 1 {
+		t.Errorf("got %d comments on the draft, want 1", n)
+	}
+
+	if w := do(h, "PUT", path+"/"+comment.Id+"?preview=true", `{"body": "edited"}`); w.Code != http.StatusOK {
+		t.Errorf("PUT %s/%s?preview=true of a draft: got %d, want 200", path, comment.Id, w.Code)
+	}
+	if w := do(h, "DELETE", path+"/"+comment.Id+"?preview=true", ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE %s/%s?preview=true of a draft: got %d, want 204", path, comment.Id, w.Code)
+	}
+}